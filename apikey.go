@@ -0,0 +1,250 @@
+package webutils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	qerrors "github.com/cyberhorsey/errors"
+	jwt "github.com/golang-jwt/jwt/v4"
+	echo "github.com/labstack/echo/v4"
+)
+
+// HeaderAPIKey is the default header API keys are read from
+const HeaderAPIKey = "X-API-Key"
+
+const apiKeySecretLength = 40
+
+// APIKey is a stored API key. Only the hash of the key is kept; the key itself is shown to its
+// owner once, when generated.
+type APIKey struct {
+	ID         string
+	Hash       string
+	Name       string
+	UserID     uint
+	TenantID   string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+}
+
+// Expired indicates whether the key has an expiry which has passed
+func (k *APIKey) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// Claims returns the principal authenticated by the key
+func (k *APIKey) Claims() *Claims {
+	claims := &Claims{
+		Type:     string(JWTAccess),
+		UserID:   k.UserID,
+		Username: k.Name,
+		Scope:    strings.Join(k.Scopes, " "),
+		TenantID: k.TenantID,
+		StandardClaims: jwt.StandardClaims{
+			Subject: k.ID,
+		},
+	}
+
+	if !k.ExpiresAt.IsZero() {
+		claims.ExpiresAt = k.ExpiresAt.Unix()
+	}
+
+	return claims
+}
+
+// GenerateAPIKey generates a new API key with the provided prefix, ie: "live" produces keys like
+// "live_9aQ...". The key is returned for handing to its owner, alongside its hash for storage.
+func GenerateAPIKey(prefix string) (key string, hash string, err error) {
+	if prefix == "" || strings.Contains(prefix, "_") {
+		return "", "", ErrInvalidAPIKeyPrefix
+	}
+
+	secret, err := SecureRandomString(apiKeySecretLength)
+	if err != nil {
+		return "", "", qerrors.Wrap(err, "SecureRandomString")
+	}
+
+	key = prefix + "_" + secret
+
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hex encoded SHA-256 hash of key. API keys are high entropy random
+// strings, so a fast hash is sufficient.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyStore persists API keys
+type APIKeyStore interface {
+	Create(ctx context.Context, key *APIKey) error
+	FindByHash(ctx context.Context, hash string) (*APIKey, error)
+	MarkUsed(ctx context.Context, id string, usedAt time.Time) error
+	Delete(ctx context.Context, id string) error
+}
+
+// InMemoryAPIKeyStore is an APIKeyStore backed by a map, useful for tests and static keys
+type InMemoryAPIKeyStore struct {
+	mu     sync.RWMutex
+	keys   map[string]*APIKey
+	hashes map[string]string
+}
+
+// NewInMemoryAPIKeyStore creates an empty InMemoryAPIKeyStore
+func NewInMemoryAPIKeyStore() *InMemoryAPIKeyStore {
+	return &InMemoryAPIKeyStore{
+		keys:   make(map[string]*APIKey),
+		hashes: make(map[string]string),
+	}
+}
+
+// Create stores key
+func (s *InMemoryAPIKeyStore) Create(ctx context.Context, key *APIKey) error {
+	if key == nil || key.ID == "" || key.Hash == "" {
+		return ErrInvalidAPIKey
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key.ID]; ok {
+		return ErrAPIKeyExists
+	}
+
+	if _, ok := s.hashes[key.Hash]; ok {
+		return ErrAPIKeyExists
+	}
+
+	stored := *key
+	s.keys[key.ID] = &stored
+	s.hashes[key.Hash] = key.ID
+
+	return nil
+}
+
+// FindByHash returns the key with the provided hash
+func (s *InMemoryAPIKeyStore) FindByHash(ctx context.Context, hash string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.hashes[hash]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	found := *s.keys[id]
+
+	return &found, nil
+}
+
+// MarkUsed records the last time the key was used
+func (s *InMemoryAPIKeyStore) MarkUsed(ctx context.Context, id string, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+
+	key.LastUsedAt = usedAt
+
+	return nil
+}
+
+// Delete removes the key, revoking it
+func (s *InMemoryAPIKeyStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+
+	delete(s.hashes, key.Hash)
+	delete(s.keys, id)
+
+	return nil
+}
+
+// APIKeyMiddlewareOpts contains the options for ConfigureAPIKeyMiddleware
+type APIKeyMiddlewareOpts struct {
+	Store   APIKeyStore
+	Skipper func(c echo.Context) bool
+	// Header the key is read from. Defaults to HeaderAPIKey.
+	Header string
+}
+
+// apiKeyMiddleware authenticates requests by API key
+type apiKeyMiddleware struct {
+	Store   APIKeyStore
+	Skipper func(c echo.Context) bool
+	Header  string
+}
+
+// ConfigureAPIKeyMiddleware configures middleware that authenticates requests by API key,
+// populating the JWT claims context values with the key's principal.
+func ConfigureAPIKeyMiddleware(opts APIKeyMiddlewareOpts) (echo.MiddlewareFunc, error) {
+	mw := apiKeyMiddleware(opts)
+	if mw.Store == nil {
+		return nil, ErrNoAPIKeyStore
+	}
+
+	if mw.Skipper == nil {
+		mw.Skipper = defaultJWTMiddlewareSkipper
+	}
+
+	if mw.Header == "" {
+		mw.Header = HeaderAPIKey
+	}
+
+	return mw.Handler, nil
+}
+
+func (mw *apiKeyMiddleware) Handler(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if mw.Skipper(c) {
+			return next(c)
+		}
+
+		key := c.Request().Header.Get(mw.Header)
+		if key == "" {
+			return LogAndRenderErrors(c, http.StatusUnauthorized, ErrAPIKeyRequired)
+		}
+
+		ctx := c.Request().Context()
+
+		apiKey, err := mw.Store.FindByHash(ctx, HashAPIKey(key))
+		if err != nil {
+			if errors.Is(err, ErrAPIKeyNotFound) {
+				return LogAndRenderErrors(c, http.StatusUnauthorized, ErrAPIKeyInvalid)
+			}
+
+			return LogAndRenderUnexpectedError(c, qerrors.Wrap(err, "mw.Store.FindByHash"))
+		}
+
+		now := time.Now()
+
+		if apiKey.Expired(now) {
+			return LogAndRenderErrors(c, http.StatusUnauthorized, ErrAPIKeyExpired)
+		}
+
+		if err := mw.Store.MarkUsed(ctx, apiKey.ID, now); err != nil {
+			return LogAndRenderUnexpectedError(c, qerrors.Wrap(err, "mw.Store.MarkUsed"))
+		}
+
+		// only the claims are set; the key is not a JWT and must never be forwarded as one
+		setJWTClaimsContext(c, apiKey.Claims())
+
+		return next(c)
+	}
+}
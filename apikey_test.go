@@ -0,0 +1,142 @@
+package webutils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GenerateAPIKey(t *testing.T) {
+	key, hash, err := GenerateAPIKey("live")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(key, "live_"))
+	assert.Equal(t, len("live_")+apiKeySecretLength, len(key))
+	assert.Equal(t, HashAPIKey(key), hash)
+	assert.NotContains(t, hash, key)
+
+	other, _, err := GenerateAPIKey("live")
+	assert.Nil(t, err)
+	assert.NotEqual(t, key, other)
+
+	_, _, err = GenerateAPIKey("")
+	assert.Equal(t, ErrInvalidAPIKeyPrefix, err)
+
+	_, _, err = GenerateAPIKey("li_ve")
+	assert.Equal(t, ErrInvalidAPIKeyPrefix, err)
+}
+
+func Test_InMemoryAPIKeyStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryAPIKeyStore()
+
+	assert.Equal(t, ErrInvalidAPIKey, store.Create(ctx, &APIKey{ID: "1"}))
+	assert.Nil(t, store.Create(ctx, &APIKey{ID: "1", Hash: "hash"}))
+	assert.Equal(t, ErrAPIKeyExists, store.Create(ctx, &APIKey{ID: "1", Hash: "other"}))
+	assert.Equal(t, ErrAPIKeyExists, store.Create(ctx, &APIKey{ID: "2", Hash: "hash"}))
+
+	key, err := store.FindByHash(ctx, "hash")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", key.ID)
+	assert.True(t, key.LastUsedAt.IsZero())
+
+	usedAt := time.Now()
+	assert.Nil(t, store.MarkUsed(ctx, "1", usedAt))
+	assert.Equal(t, ErrAPIKeyNotFound, store.MarkUsed(ctx, "2", usedAt))
+
+	key, err = store.FindByHash(ctx, "hash")
+	assert.Nil(t, err)
+	assert.Equal(t, usedAt, key.LastUsedAt)
+
+	assert.Nil(t, store.Delete(ctx, "1"))
+	assert.Equal(t, ErrAPIKeyNotFound, store.Delete(ctx, "1"))
+
+	_, err = store.FindByHash(ctx, "hash")
+	assert.Equal(t, ErrAPIKeyNotFound, err)
+}
+
+func Test_APIKeyMiddleware(t *testing.T) {
+	_, err := ConfigureAPIKeyMiddleware(APIKeyMiddlewareOpts{})
+	assert.Equal(t, ErrNoAPIKeyStore, err)
+
+	ctx := context.Background()
+	store := NewInMemoryAPIKeyStore()
+
+	validKey, validHash, err := GenerateAPIKey("test")
+	assert.Nil(t, err)
+	assert.Nil(t, store.Create(ctx, &APIKey{
+		ID:     "cron",
+		Hash:   validHash,
+		Name:   "nightly cron",
+		UserID: 9,
+		Scopes: []string{"reports:read", "reports:write"},
+	}))
+
+	expiredKey, expiredHash, err := GenerateAPIKey("test")
+	assert.Nil(t, err)
+	assert.Nil(t, store.Create(ctx, &APIKey{
+		ID:        "partner",
+		Hash:      expiredHash,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}))
+
+	mw, err := ConfigureAPIKeyMiddleware(APIKeyMiddlewareOpts{Store: store})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name       string
+		key        string
+		wantStatus int
+		wantKey    string
+	}{
+		{"valid", validKey, http.StatusNoContent, ""},
+		{"missing", "", http.StatusUnauthorized, "ERR_API_KEY_REQUIRED"},
+		{"unknown", "test_unknown", http.StatusUnauthorized, "ERR_API_KEY_INVALID"},
+		{"expired", expiredKey, http.StatusUnauthorized, "ERR_API_KEY_EXPIRED"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims *Claims
+
+			var jwtErr error
+
+			e := echo.New()
+			e.Use(mw)
+			e.GET("/reports", func(c echo.Context) error {
+				claims, _ = GetJWTClaimsFromContext(c.Request().Context())
+				_, jwtErr = GetJWTFromContext(c.Request().Context())
+
+				return c.NoContent(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/reports", nil)
+			if tt.key != "" {
+				req.Header.Set(HeaderAPIKey, tt.key)
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.wantKey)
+
+			if tt.wantStatus == http.StatusNoContent {
+				assert.Equal(t, "cron", claims.Subject)
+				assert.Equal(t, uint(9), claims.UserID)
+				assert.Equal(t, "nightly cron", claims.Username)
+				assert.True(t, claims.HasScope("reports:write"))
+				assert.Equal(t, ErrNoJWTInContext, jwtErr)
+			}
+		})
+	}
+
+	key, err := store.FindByHash(ctx, validHash)
+	assert.Nil(t, err)
+	assert.False(t, key.LastUsedAt.IsZero())
+}
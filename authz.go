@@ -0,0 +1,137 @@
+package webutils
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	qerrors "github.com/cyberhorsey/errors"
+	echo "github.com/labstack/echo/v4"
+)
+
+// contextKeyAuthRealm is the echo.Context key the authenticating middleware stores its realm
+// under, so authorization helpers further down the chain issue challenges for the same realm
+const contextKeyAuthRealm = "auth-realm"
+
+// RFC 6750 and RFC 9449 error codes
+const (
+	bearerErrorInvalidRequest    = "invalid_request"
+	bearerErrorInvalidToken      = "invalid_token"
+	bearerErrorInsufficientScope = "insufficient_scope"
+	dpopErrorInvalidProof        = "invalid_dpop_proof"
+)
+
+// BearerChallenge returns an RFC 6750 WWW-Authenticate header value for err. A nil err, used
+// when a request carried no credentials at all, results in a challenge without an error code.
+func BearerChallenge(realm string, err error, scopes ...string) string {
+	var params []string
+
+	if len(scopes) > 0 {
+		params = append(params, challengeParam("scope", strings.Join(scopes, " ")))
+	}
+
+	return authChallenge("Bearer", realm, err, params...)
+}
+
+// authChallenge returns a WWW-Authenticate header value for the scheme, followed by the realm,
+// the error code and description for err, and params
+func authChallenge(scheme, realm string, err error, params ...string) string {
+	all := make([]string, 0)
+
+	if realm != "" {
+		all = append(all, challengeParam("realm", realm))
+	}
+
+	if err != nil {
+		code := bearerErrorInvalidToken
+
+		switch {
+		case errors.Is(err, ErrAuthorizationBearerRequired):
+			code = bearerErrorInvalidRequest
+		case errors.Is(err, ErrAuthorizationInsufficientScope):
+			code = bearerErrorInsufficientScope
+		case isDPoPProofError(err):
+			code = dpopErrorInvalidProof
+		}
+
+		all = append(all, challengeParam("error", code))
+
+		if detail := qerrors.Detail(err); detail != "" {
+			all = append(all, challengeParam("error_description", detail))
+		}
+	}
+
+	all = append(all, params...)
+
+	if len(all) == 0 {
+		return scheme
+	}
+
+	return scheme + " " + strings.Join(all, ", ")
+}
+
+// isDPoPProofError indicates whether err rejected a missing or invalid DPoP proof
+func isDPoPProofError(err error) bool {
+	return errors.Is(err, ErrAuthorizationDPoPProofRequired) || errors.Is(err, ErrAuthorizationDPoPProofInvalid)
+}
+
+func challengeParam(name, value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+
+	return name + `="` + value + `"`
+}
+
+// setBearerChallenge sets the WWW-Authenticate header of the response
+func setBearerChallenge(c echo.Context, realm string, err error, scopes ...string) {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, BearerChallenge(realm, err, scopes...))
+}
+
+// RequireScopes returns middleware rejecting requests whose claims were not granted every one of
+// scopes. Requests without claims receive a 401 and those lacking a scope a 403, each with a
+// WWW-Authenticate challenge for the realm of the authenticating middleware.
+func RequireScopes(scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			realm, _ := c.Get(contextKeyAuthRealm).(string)
+
+			claims, err := GetJWTClaimsFromContext(c.Request().Context())
+			if err != nil {
+				setBearerChallenge(c, realm, nil)
+				return LogAndRenderErrors(c, http.StatusUnauthorized, ErrAuthorizationAccessTokenRequired)
+			}
+
+			for _, scope := range scopes {
+				if !claims.HasScope(scope) {
+					setBearerChallenge(c, realm, ErrAuthorizationInsufficientScope, scopes...)
+					return LogAndRenderErrors(c, http.StatusForbidden, ErrAuthorizationInsufficientScope)
+				}
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// ForbidImpersonation returns middleware rejecting impersonated requests with a 403, ie: for
+// changing credentials, which only the subject themselves should do
+func ForbidImpersonation() echo.MiddlewareFunc {
+	return RestrictImpersonation(func(actor *Actor) bool {
+		return false
+	})
+}
+
+// RestrictImpersonation returns middleware rejecting impersonated requests with a 403 unless
+// allow approves of their actor. Requests which aren't impersonated are unaffected.
+func RestrictImpersonation(allow func(actor *Actor) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, err := GetJWTClaimsFromContext(c.Request().Context())
+			if err == nil && claims.Impersonated() && !allow(claims.Actor) {
+				return LogAndRenderErrors(c, http.StatusForbidden, ErrImpersonationForbidden)
+			}
+
+			return next(c)
+		}
+	}
+}
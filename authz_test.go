@@ -0,0 +1,148 @@
+package webutils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_BearerChallenge(t *testing.T) {
+	tests := []struct {
+		name  string
+		realm string
+		err   error
+		want  string
+	}{
+		{"no credentials", "api", nil, `Bearer realm="api"`},
+		{"no realm", "", nil, "Bearer"},
+		{
+			"bearer required",
+			"api",
+			ErrAuthorizationBearerRequired,
+			`Bearer realm="api", error="invalid_request", error_description="Authorization Bearer is required before token"`,
+		},
+		{
+			"token invalid",
+			"api",
+			errors.New("expired"),
+			`Bearer realm="api", error="invalid_token"`,
+		},
+		{"escaped realm", `a"b`, nil, `Bearer realm="a\"b"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, BearerChallenge(tt.realm, tt.err))
+		})
+	}
+
+	challenge := BearerChallenge("api", ErrAuthorizationAccessTokenRequired)
+	assert.Contains(t, challenge, `error="invalid_token"`)
+
+	challenge = BearerChallenge("api", ErrAuthorizationInsufficientScope, "reports:read", "reports:write")
+	assert.Contains(t, challenge, `error="insufficient_scope"`)
+	assert.Contains(t, challenge, `scope="reports:read reports:write"`)
+}
+
+func Test_JWTMiddleware_Challenge(t *testing.T) {
+	mw, err := ConfigureJWTMiddleware(JWTMiddlewareOpts{PublicKey: testPublicKeyFunc, Realm: "api"})
+	assert.Nil(t, err)
+
+	rec, _ := serveJWTMiddleware(t, mw, "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Bearer realm="api"`, rec.Header().Get(echo.HeaderWWWAuthenticate))
+
+	rec, _ = serveJWTMiddleware(t, mw, "Basic dXNlcjpwYXNz")
+	assert.Contains(t, rec.Header().Get(echo.HeaderWWWAuthenticate), `error="invalid_request"`)
+
+	rec, _ = serveJWTMiddleware(t, mw, "Bearer "+newTestExpiredJWT(t))
+	assert.Contains(t, rec.Header().Get(echo.HeaderWWWAuthenticate), `error="invalid_token"`)
+
+	rec, _ = serveJWTMiddleware(t, mw, "Bearer "+newTestJWT(t, newTestClaims(JWTRefresh, time.Hour)))
+	assert.Contains(t, rec.Header().Get(echo.HeaderWWWAuthenticate), `error="invalid_token"`)
+
+	rec, _ = serveJWTMiddleware(t, mw, "Bearer "+newTestJWT(t, newTestClaims(JWTAccess, time.Hour)))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "", rec.Header().Get(echo.HeaderWWWAuthenticate))
+}
+
+func Test_RequireScopes(t *testing.T) {
+	jwtMiddleware, err := ConfigureJWTMiddleware(JWTMiddlewareOpts{
+		PublicKey: testPublicKeyFunc,
+		Realm:     "api",
+		Optional:  true,
+	})
+	assert.Nil(t, err)
+
+	e := echo.New()
+	e.Use(jwtMiddleware)
+	e.GET("/reports", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}, RequireScopes("reports:read"))
+
+	serve := func(scope string, authenticated bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/reports", nil)
+
+		if authenticated {
+			claims := newTestClaims(JWTAccess, time.Hour)
+			claims.Scope = scope
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+newTestJWT(t, claims))
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := serve("", false)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Bearer realm="api"`, rec.Header().Get(echo.HeaderWWWAuthenticate))
+
+	rec = serve("profile", true)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Header().Get(echo.HeaderWWWAuthenticate), `error="insufficient_scope"`)
+	assert.Contains(t, rec.Header().Get(echo.HeaderWWWAuthenticate), `scope="reports:read"`)
+
+	rec = serve("profile reports:read", true)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
+
+func Test_RestrictImpersonation(t *testing.T) {
+	serve := func(mw echo.MiddlewareFunc, claims *Claims) int {
+		e := echo.New()
+		e.GET("/account/password", func(c echo.Context) error {
+			return c.NoContent(http.StatusNoContent)
+		}, func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				if claims != nil {
+					setJWTContext(c, claims, "token")
+				}
+
+				return next(c)
+			}
+		}, mw)
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/account/password", nil))
+
+		return rec.Code
+	}
+
+	impersonated := &Claims{UserID: 42, Actor: &Actor{Subject: "support-1"}}
+	restricted := RestrictImpersonation(func(actor *Actor) bool {
+		return strings.HasPrefix(actor.Subject, "support-")
+	})
+
+	assert.Equal(t, http.StatusNoContent, serve(ForbidImpersonation(), nil))
+	assert.Equal(t, http.StatusNoContent, serve(ForbidImpersonation(), &Claims{UserID: 42}))
+	assert.Equal(t, http.StatusForbidden, serve(ForbidImpersonation(), impersonated))
+	assert.Equal(t, http.StatusNoContent, serve(restricted, impersonated))
+	assert.Equal(t, http.StatusForbidden, serve(restricted, &Claims{Actor: &Actor{Subject: "billing"}}))
+}
@@ -0,0 +1,141 @@
+package webutils
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/cyberhorsey/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const loggerKey ctxKey = ctxKey(4205)
+
+// detachedContext keeps the values of its parent, but is never canceled and has no deadline
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+func (d detachedContext) String() string {
+	return fmt.Sprintf("%v.Detached", d.parent)
+}
+
+// Detach returns a context with the values of ctx, ie: the provenance and request ids, trace
+// context, JWT, claims and logger, which isn't canceled when ctx is. Use it for work which
+// outlives a request, ie: after the response is sent; add a timeout so it can't run forever.
+func Detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+// WithLogger returns a copy of ctx carrying l, returned by LoggerFromContext
+func WithLogger(ctx context.Context, l *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// LoggerFromContext returns the logger in ctx, or the package logger with the provenance id,
+// request id, trace context and tenant of ctx as fields
+func LoggerFromContext(ctx context.Context) *logrus.Entry {
+	if l, ok := ctx.Value(loggerKey).(*logrus.Entry); ok {
+		return l
+	}
+
+	return logger.WithFields(logFields(ctx))
+}
+
+// BackgroundRunner runs functions in goroutines with detached contexts, recovering their panics,
+// and can wait for them when shutting down
+type BackgroundRunner struct {
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewBackgroundRunner creates a BackgroundRunner
+func NewBackgroundRunner() *BackgroundRunner {
+	return &BackgroundRunner{}
+}
+
+var defaultBackgroundRunner = NewBackgroundRunner()
+
+// Go runs fn with the default BackgroundRunner
+func Go(ctx context.Context, fn func(ctx context.Context) error) {
+	defaultBackgroundRunner.Go(ctx, fn)
+}
+
+// WaitForBackground waits for the functions started with Go, until ctx is done
+func WaitForBackground(ctx context.Context) error {
+	return defaultBackgroundRunner.Wait(ctx)
+}
+
+// Go runs fn in a goroutine with Detach(ctx). Errors returned and panics are logged with the
+// correlation ids of ctx. Once Wait has been called, ie: while requests are still arriving during
+// shutdown, fn is run before Go returns instead, so it isn't lost.
+func (r *BackgroundRunner) Go(ctx context.Context, fn func(ctx context.Context) error) {
+	ctx = Detach(ctx)
+
+	r.mu.Lock()
+
+	if r.closed {
+		r.mu.Unlock()
+		runBackground(ctx, fn)
+
+		return
+	}
+
+	// added under the lock, so never concurrently with Wait
+	r.wg.Add(1)
+	r.mu.Unlock()
+
+	go func() {
+		defer r.wg.Done()
+
+		runBackground(ctx, fn)
+	}()
+}
+
+// runBackground runs fn, logging its error or panic
+func runBackground(ctx context.Context, fn func(ctx context.Context) error) {
+	defer func() {
+		if p := recover(); p != nil {
+			LoggerFromContext(ctx).
+				WithField("stack", string(debug.Stack())).
+				Error(errors.Wrapf(ErrBackgroundPanic, "%v", p))
+		}
+	}()
+
+	if err := fn(ctx); err != nil {
+		LoggerFromContext(ctx).Error(err)
+	}
+}
+
+// Wait waits for the running functions to return, or until ctx is done, ie: to bound how long
+// shutdown takes. Functions passed to Go afterwards run synchronously.
+func (r *BackgroundRunner) Wait(ctx context.Context) error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,142 @@
+package webutils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Detach(t *testing.T) {
+	ctx := NewContext(context.Background(), "pid", "rid")
+	ctx = newJWTContext(ctx, &Claims{UserID: 42}, "token")
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	cancel()
+
+	detached := Detach(ctx)
+	assert.NotNil(t, ctx.Err())
+	assert.Nil(t, detached.Err())
+	assert.Nil(t, detached.Done())
+
+	_, ok := detached.Deadline()
+	assert.False(t, ok)
+
+	pid, _ := ProvenanceIDFromContext(detached)
+	rid, _ := RequestIDFromContext(detached)
+	assert.Equal(t, "pid", pid)
+	assert.Equal(t, "rid", rid)
+
+	claims, err := GetJWTClaimsFromContext(detached)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(42), claims.UserID)
+
+	jwt, err := GetJWTFromContext(detached)
+	assert.Nil(t, err)
+	assert.Equal(t, "token", jwt)
+}
+
+func Test_LoggerFromContext(t *testing.T) {
+	ctx := NewContext(context.Background(), "pid", "rid")
+	assert.Equal(t, "pid", LoggerFromContext(ctx).Data["provenanceId"])
+
+	l := logrus.NewEntry(logrus.New()).WithField("job", "export")
+	assert.Equal(t, l, LoggerFromContext(Detach(WithLogger(ctx, l))))
+}
+
+func Test_BackgroundRunner(t *testing.T) {
+	out := logger.Out
+	buf := &bytes.Buffer{}
+	logger.SetOutput(buf)
+
+	defer logger.SetOutput(out)
+
+	r := NewBackgroundRunner()
+
+	ctx, cancel := context.WithCancel(NewContext(context.Background(), "pid", "rid"))
+
+	release := make(chan struct{})
+
+	var ranErr error
+
+	r.Go(ctx, func(ctx context.Context) error {
+		<-release
+
+		ranErr = ctx.Err()
+
+		return errors.New("export failed")
+	})
+	r.Go(ctx, func(ctx context.Context) error {
+		panic("boom")
+	})
+
+	// the request ending doesn't cancel background work
+	cancel()
+
+	// waiting is bounded
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer waitCancel()
+
+	assert.Equal(t, context.DeadlineExceeded, r.Wait(waitCtx))
+
+	close(release)
+	assert.Nil(t, r.Wait(context.Background()))
+	assert.Nil(t, ranErr)
+
+	logged := buf.String()
+	assert.Contains(t, logged, "export failed")
+	assert.Contains(t, logged, "boom: background function panicked")
+	assert.Contains(t, logged, `"provenanceId":"pid"`)
+	assert.Contains(t, logged, `"stack"`)
+}
+
+func Test_BackgroundRunner_GoDuringWait(t *testing.T) {
+	r := NewBackgroundRunner()
+
+	var ran int32
+
+	stop := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan struct{})
+
+	// requests still arriving while shutting down
+	go func() {
+		defer close(done)
+
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				r.Go(context.Background(), func(ctx context.Context) error {
+					atomic.AddInt32(&ran, 1)
+					return nil
+				})
+			}
+
+			if i == 0 {
+				close(started)
+			}
+		}
+	}()
+
+	<-started
+	assert.Nil(t, r.Wait(context.Background()))
+
+	close(stop)
+	<-done
+
+	// started after Wait, so run before Go returns
+	before := atomic.LoadInt32(&ran)
+
+	r.Go(context.Background(), func(ctx context.Context) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	})
+	assert.Equal(t, before+1, atomic.LoadInt32(&ran))
+}
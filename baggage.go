@@ -0,0 +1,379 @@
+package webutils
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// BaggageHeader is the W3C Baggage header, also used as the gRPC metadata key
+const BaggageHeader = "baggage"
+
+const (
+	baggageKey         ctxKey = ctxKey(4206)
+	baggageLogKeysKey  ctxKey = ctxKey(4207)
+	baggageLogFieldTag        = "baggage."
+
+	// the minimums the W3C Baggage spec requires propagating
+	defaultMaxBaggageMembers = 64
+	defaultMaxBaggageBytes   = 8192
+)
+
+// Baggage are the key/values of a W3C Baggage header, ie: a tenant, experiment cohort or client
+// app version carried across service hops. Member properties are not kept. Baggage is treated as
+// immutable; use WithBaggageValue to add to a context's baggage.
+type Baggage map[string]string
+
+// Get returns the value of key
+func (b Baggage) Get(key string) (string, bool) {
+	v, ok := b[key]
+	return v, ok
+}
+
+// Int returns the value of key as an integer
+func (b Baggage) Int(key string) (int64, bool) {
+	i, err := strconv.ParseInt(b[key], 10, 64)
+	return i, err == nil
+}
+
+// Bool returns the value of key as a boolean, ie: "true" or "1"
+func (b Baggage) Bool(key string) (bool, bool) {
+	v, err := strconv.ParseBool(b[key])
+	return v, err == nil
+}
+
+// String encodes b as a baggage header, with members sorted by key
+func (b Baggage) String() string {
+	return strings.Join(b.members(), ",")
+}
+
+// members returns the encoded members of b, sorted by key
+func (b Baggage) members() []string {
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	members := make([]string, 0, len(keys))
+	for _, k := range keys {
+		members = append(members, k+"="+url.PathEscape(b[k]))
+	}
+
+	return members
+}
+
+// ParseBaggage parses a baggage header, returning ErrInvalidBaggage for malformed members
+func ParseBaggage(header string) (Baggage, error) {
+	b := Baggage{}
+
+	for _, member := range strings.Split(header, ",") {
+		if strings.TrimSpace(member) == "" {
+			continue
+		}
+
+		key, value, ok := parseBaggageMember(member)
+		if !ok {
+			return nil, ErrInvalidBaggage
+		}
+
+		b[key] = value
+	}
+
+	return b, nil
+}
+
+// parseBaggageMember parses a "key=value;properties" member
+func parseBaggageMember(member string) (key, value string, ok bool) {
+	if i := strings.IndexByte(member, ';'); i >= 0 {
+		member = member[:i]
+	}
+
+	i := strings.IndexByte(member, '=')
+	if i < 0 {
+		return "", "", false
+	}
+
+	key = strings.TrimSpace(member[:i])
+	if !isBaggageKey(key) {
+		return "", "", false
+	}
+
+	value, err := url.PathUnescape(strings.TrimSpace(member[i+1:]))
+	if err != nil {
+		return "", "", false
+	}
+
+	return key, value, true
+}
+
+// isBaggageKey indicates whether key is an RFC 7230 token
+func isBaggageKey(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for _, r := range key {
+		if r > 0x7e || r <= 0x20 || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// BaggageOpts limits the baggage accepted from, and propagated to, other services
+type BaggageOpts struct {
+	// AllowedKeys are the keys accepted and propagated; all keys are when empty
+	AllowedKeys []string
+	// LogKeys are included in Logger fields as "baggage.<key>"
+	LogKeys []string
+	// MaxMembers defaults to 64
+	MaxMembers int
+	// MaxBytes of the encoded header. Defaults to 8192.
+	MaxBytes int
+}
+
+// baggageLimits applies BaggageOpts
+type baggageLimits struct {
+	allowed    map[string]bool
+	logKeys    []string
+	maxMembers int
+	maxBytes   int
+}
+
+func newBaggageLimits(opts BaggageOpts) *baggageLimits {
+	l := &baggageLimits{
+		logKeys:    opts.LogKeys,
+		maxMembers: opts.MaxMembers,
+		maxBytes:   opts.MaxBytes,
+	}
+
+	if len(opts.AllowedKeys) > 0 {
+		l.allowed = make(map[string]bool, len(opts.AllowedKeys))
+		for _, k := range opts.AllowedKeys {
+			l.allowed[k] = true
+		}
+	}
+
+	if l.maxMembers <= 0 {
+		l.maxMembers = defaultMaxBaggageMembers
+	}
+
+	if l.maxBytes <= 0 {
+		l.maxBytes = defaultMaxBaggageBytes
+	}
+
+	return l
+}
+
+// parse parses header leniently: malformed and disallowed members are dropped, as are members
+// beyond the limits
+func (l *baggageLimits) parse(header string) Baggage {
+	if len(header) > l.maxBytes {
+		header = header[:l.maxBytes]
+		// the last member may have been cut short
+		if i := strings.LastIndexByte(header, ','); i >= 0 {
+			header = header[:i]
+		} else {
+			header = ""
+		}
+	}
+
+	b := Baggage{}
+
+	for _, member := range strings.Split(header, ",") {
+		if len(b) >= l.maxMembers {
+			break
+		}
+
+		key, value, ok := parseBaggageMember(member)
+		if ok && l.allows(key) {
+			b[key] = value
+		}
+	}
+
+	return b
+}
+
+// encode encodes the allowed members of b within the limits
+func (l *baggageLimits) encode(b Baggage) string {
+	var (
+		members []string
+		size    int
+	)
+
+	for _, member := range b.members() {
+		key := member[:strings.IndexByte(member, '=')]
+		if !l.allows(key) {
+			continue
+		}
+
+		// members are comma separated
+		if len(members) >= l.maxMembers || size+len(member)+len(members) > l.maxBytes {
+			break
+		}
+
+		members = append(members, member)
+		size += len(member)
+	}
+
+	return strings.Join(members, ",")
+}
+
+func (l *baggageLimits) allows(key string) bool {
+	return l.allowed == nil || l.allowed[key]
+}
+
+// WithBaggage returns a copy of ctx carrying b
+func WithBaggage(ctx context.Context, b Baggage) context.Context {
+	return context.WithValue(ctx, baggageKey, b)
+}
+
+// WithBaggageValue returns a copy of ctx whose baggage has key set to value
+func WithBaggageValue(ctx context.Context, key, value string) context.Context {
+	b := Baggage{key: value}
+	for k, v := range BaggageFromContext(ctx) {
+		if k != key {
+			b[k] = v
+		}
+	}
+
+	return WithBaggage(ctx, b)
+}
+
+// BaggageFromContext returns the baggage from context, empty when there is none
+func BaggageFromContext(ctx context.Context) Baggage {
+	b, ok := ctx.Value(baggageKey).(Baggage)
+	if !ok {
+		return Baggage{}
+	}
+
+	return b
+}
+
+// baggageLogFields returns the baggage values of the log keys configured by the baggage
+// middleware or interceptors
+func baggageLogFields(ctx context.Context) logrus.Fields {
+	fields := logrus.Fields{}
+
+	keys, _ := ctx.Value(baggageLogKeysKey).([]string)
+	b := BaggageFromContext(ctx)
+
+	for _, k := range keys {
+		if v, ok := b[k]; ok {
+			fields[baggageLogFieldTag+k] = v
+		}
+	}
+
+	return fields
+}
+
+// withInboundBaggage returns a copy of ctx carrying the baggage of an inbound header
+func (l *baggageLimits) withInboundBaggage(ctx context.Context, header string) context.Context {
+	ctx = WithBaggage(ctx, l.parse(header))
+
+	if len(l.logKeys) > 0 {
+		ctx = context.WithValue(ctx, baggageLogKeysKey, l.logKeys)
+	}
+
+	return ctx
+}
+
+// ConfigureBaggageMiddleware configures middleware parsing the baggage header into the request
+// context, for BaggageFromContext
+func ConfigureBaggageMiddleware(opts BaggageOpts) echo.MiddlewareFunc {
+	l := newBaggageLimits(opts)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := l.withInboundBaggage(c.Request().Context(), c.Request().Header.Get(BaggageHeader))
+			c.SetRequest(c.Request().WithContext(ctx))
+
+			return next(c)
+		}
+	}
+}
+
+// ConfigureGRPCBaggageInterceptors configures unary and stream server interceptors parsing the
+// baggage metadata of calls into their context
+func ConfigureGRPCBaggageInterceptors(opts BaggageOpts) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	l := newBaggageLimits(opts)
+
+	inbound := func(ctx context.Context) context.Context {
+		var header string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			header = strings.Join(md.Get(BaggageHeader), ",")
+		}
+
+		return l.withInboundBaggage(ctx, header)
+	}
+
+	unary := func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		return handler(inbound(ctx), req)
+	}
+
+	stream := func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		return handler(srv, &contextServerStream{ServerStream: ss, ctx: inbound(ss.Context())})
+	}
+
+	return unary, stream
+}
+
+// ConfigureGRPCBaggageClientInterceptors configures unary and stream client interceptors
+// propagating the baggage of the call's context as metadata
+func ConfigureGRPCBaggageClientInterceptors(
+	opts BaggageOpts,
+) (grpc.UnaryClientInterceptor, grpc.StreamClientInterceptor) {
+	l := newBaggageLimits(opts)
+
+	outbound := func(ctx context.Context) context.Context {
+		if header := l.encode(BaggageFromContext(ctx)); header != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, BaggageHeader, header)
+		}
+
+		return ctx
+	}
+
+	unary := func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		callOpts ...grpc.CallOption,
+	) error {
+		return invoker(outbound(ctx), method, req, reply, cc, callOpts...)
+	}
+
+	stream := func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		callOpts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		return streamer(outbound(ctx), desc, cc, method, callOpts...)
+	}
+
+	return unary, stream
+}
@@ -0,0 +1,216 @@
+package webutils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func Test_ParseBaggage(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    Baggage
+		wantErr error
+	}{
+		{"empty", "", Baggage{}, nil},
+		{"single", "tenant=acme", Baggage{"tenant": "acme"}, nil},
+		{"multiple with spaces", "tenant = acme , cohort=b", Baggage{"tenant": "acme", "cohort": "b"}, nil},
+		{"percent encoded", "name=Jane%20Doe%2C%20PhD", Baggage{"name": "Jane Doe, PhD"}, nil},
+		{"properties dropped", "tenant=acme;ttl=30", Baggage{"tenant": "acme"}, nil},
+		{"empty value", "flag=", Baggage{"flag": ""}, nil},
+		{"no value", "tenant", nil, ErrInvalidBaggage},
+		{"invalid key", "ten ant=acme", nil, ErrInvalidBaggage},
+		{"invalid escape", "tenant=%zz", nil, ErrInvalidBaggage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := ParseBaggage(tt.header)
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.want, b)
+		})
+	}
+}
+
+func Test_Baggage(t *testing.T) {
+	b := Baggage{"tenant": "acme", "version": "42", "beta": "true", "name": "Jane Doe, PhD"}
+
+	v, ok := b.Get("tenant")
+	assert.True(t, ok)
+	assert.Equal(t, "acme", v)
+
+	_, ok = b.Get("missing")
+	assert.False(t, ok)
+
+	i, ok := b.Int("version")
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), i)
+
+	_, ok = b.Int("tenant")
+	assert.False(t, ok)
+
+	beta, ok := b.Bool("beta")
+	assert.True(t, ok)
+	assert.True(t, beta)
+
+	_, ok = b.Bool("missing")
+	assert.False(t, ok)
+
+	assert.Equal(t, "beta=true,name=Jane%20Doe%2C%20PhD,tenant=acme,version=42", b.String())
+
+	parsed, err := ParseBaggage(b.String())
+	assert.Nil(t, err)
+	assert.Equal(t, b, parsed)
+}
+
+func Test_baggageLimits(t *testing.T) {
+	l := newBaggageLimits(BaggageOpts{AllowedKeys: []string{"tenant", "cohort"}, MaxMembers: 1})
+
+	assert.Equal(t, Baggage{"tenant": "acme"}, l.parse("secret=x,bad,tenant=acme,cohort=b"))
+	assert.Equal(t, "cohort=b", l.encode(Baggage{"secret": "x", "tenant": "acme", "cohort": "b"}))
+
+	l = newBaggageLimits(BaggageOpts{MaxBytes: 20})
+
+	// the member cut short by the limit is dropped
+	assert.Equal(t, Baggage{"a": "1", "b": "2"}, l.parse("a=1,b=2,c=01234567890"))
+	assert.Equal(t, "a=1,b=2", l.encode(Baggage{"a": "1", "b": "2", "c": "01234567890"}))
+	assert.Equal(t, Baggage{}, l.parse("tenant="+strings.Repeat("a", 30)))
+
+	l = newBaggageLimits(BaggageOpts{})
+	assert.Equal(t, defaultMaxBaggageMembers, l.maxMembers)
+	assert.Equal(t, defaultMaxBaggageBytes, l.maxBytes)
+}
+
+func Test_WithBaggageValue(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, Baggage{}, BaggageFromContext(ctx))
+
+	parent := WithBaggage(ctx, Baggage{"tenant": "acme"})
+	child := WithBaggageValue(parent, "cohort", "b")
+
+	assert.Equal(t, Baggage{"tenant": "acme"}, BaggageFromContext(parent))
+	assert.Equal(t, Baggage{"tenant": "acme", "cohort": "b"}, BaggageFromContext(child))
+}
+
+func Test_ConfigureBaggageMiddleware(t *testing.T) {
+	e := echo.New()
+
+	var (
+		baggage Baggage
+		ctx     context.Context
+	)
+
+	e.Use(ConfigureBaggageMiddleware(BaggageOpts{
+		AllowedKeys: []string{"tenant", "cohort"},
+		LogKeys:     []string{"cohort"},
+	}))
+	e.GET("/", func(c echo.Context) error {
+		ctx = c.Request().Context()
+		baggage = BaggageFromContext(ctx)
+
+		return c.NoContent(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(BaggageHeader, "tenant=acme,cohort=b,secret=x")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, Baggage{"tenant": "acme", "cohort": "b"}, baggage)
+	assert.Equal(t, "b", logFields(ctx)["baggage.cohort"])
+
+	_, ok := logFields(ctx)["baggage.tenant"]
+	assert.False(t, ok)
+}
+
+func Test_ConfigureGRPCBaggageInterceptors(t *testing.T) {
+	unary, stream := ConfigureGRPCBaggageInterceptors(BaggageOpts{AllowedKeys: []string{"tenant"}})
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(BaggageHeader, "tenant=acme,secret=x"))
+
+	var baggage Baggage
+
+	_, err := unary(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		baggage = BaggageFromContext(ctx)
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, Baggage{"tenant": "acme"}, baggage)
+
+	baggage = nil
+
+	err = stream(nil, &testServerStream{ctx: ctx}, &grpc.StreamServerInfo{},
+		func(srv interface{}, ss grpc.ServerStream) error {
+			baggage = BaggageFromContext(ss.Context())
+			return nil
+		},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, Baggage{"tenant": "acme"}, baggage)
+}
+
+func Test_ConfigureGRPCBaggageClientInterceptors(t *testing.T) {
+	unary, stream := ConfigureGRPCBaggageClientInterceptors(BaggageOpts{AllowedKeys: []string{"tenant"}})
+
+	ctx := WithBaggage(context.Background(), Baggage{"tenant": "acme", "secret": "x"})
+
+	var md metadata.MD
+
+	err := unary(ctx, "/svc.Users/Get", nil, nil, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tenant=acme"}, md.Get(BaggageHeader))
+
+	md = nil
+
+	_, err = stream(context.Background(), &grpc.StreamDesc{}, nil, "/svc.Users/List",
+		func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (
+			grpc.ClientStream, error,
+		) {
+			md, _ = metadata.FromOutgoingContext(ctx)
+			return nil, nil
+		},
+	)
+	assert.Nil(t, err)
+	assert.Empty(t, md.Get(BaggageHeader))
+}
+
+func Test_PropagationTransport_Baggage(t *testing.T) {
+	base := &recordingTransport{}
+
+	rt, err := NewPropagationTransport(PropagationTransportOpts{
+		Base: base,
+		Destinations: []TransportDestination{
+			{Host: "users.internal", ForwardBaggage: true},
+			{Host: "billing.internal"},
+		},
+		Baggage: BaggageOpts{AllowedKeys: []string{"tenant"}},
+	})
+	assert.Nil(t, err)
+
+	ctx := WithBaggage(context.Background(), Baggage{"tenant": "acme", "secret": "x"})
+
+	req := httptest.NewRequest(http.MethodGet, "http://users.internal/users", nil).WithContext(ctx)
+	_, err = rt.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant=acme", base.req.Header.Get(BaggageHeader))
+
+	req = httptest.NewRequest(http.MethodGet, "http://billing.internal/invoices", nil).WithContext(ctx)
+	_, err = rt.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Empty(t, base.req.Header.Get(BaggageHeader))
+}
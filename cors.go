@@ -1,30 +1,383 @@
 package webutils
 
 import (
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/cyberhorsey/errors"
 	echo "github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
 )
 
-// ConfigureCORSMiddleware returns an echo.MiddlewareFunc with the whitelisted corsDomains origins
+const corsAllOrigins = "*"
+
+// ConfigureCORSMiddleware returns an echo.MiddlewareFunc with the whitelisted corsDomains origins,
+// or those in the CORS_DOMAINS environment variable when corsDomains is nil. Credentials are
+// allowed unless "*" allows any origin, which browsers refuse credentials for. Invalid origins are
+// logged and no origins allowed; use ConfigureStrictCORSMiddleware to have them reported instead.
 func ConfigureCORSMiddleware(corsDomains []string) echo.MiddlewareFunc {
+	cfg := newDomainsCORSConfig(corsDomains)
+	if !cfg.allowsAnyOrigin() {
+		cfg = cfg.WithCredentials()
+	}
+
+	mw, err := cfg.Middleware()
+	if err != nil {
+		logger.Error(errors.Wrap(err, "cfg.Middleware"))
+
+		mw, _ = NewCORSConfig().Middleware()
+	}
+
+	return mw
+}
+
+// ConfigureStrictCORSMiddleware is ConfigureCORSMiddleware always allowing credentials, which
+// returns configuration errors to report at startup, ie: a "*" origin or an unset CORS_DOMAINS
+func ConfigureStrictCORSMiddleware(corsDomains []string) (echo.MiddlewareFunc, error) {
+	return newDomainsCORSConfig(corsDomains).WithCredentials().Middleware()
+}
+
+// newDomainsCORSConfig returns a CORSConfig allowing corsDomains, or the CORS_DOMAINS environment
+// variable when nil
+func newDomainsCORSConfig(corsDomains []string) *CORSConfig {
 	// CORS_DOMAINS env var can be one url or a comma-delinated list of urls, ie:
 	// http://localhost:8004,https://website.com
 	if corsDomains == nil {
-		corsDomains = strings.Split(os.Getenv("CORS_DOMAINS"), ",")
+		return NewCORSConfig().WithOriginsFromEnv("CORS_DOMAINS")
 	}
 
-	return middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowCredentials: true,
-		AllowOrigins:     corsDomains,
-		// which we can explode
-		AllowHeaders: []string{
+	return NewCORSConfig().WithOrigins(corsDomains...)
+}
+
+// CORSConfig builds CORS middleware. Origins are exact, ie: "https://example.com", may match
+// any subdomain, ie: "https://*.example.com", or may be "*" for any origin when credentials are
+// not allowed. Configuration errors are reported by Middleware. Rejected origins are logged with
+// the request's provenance ID, so ProvenanceIDMiddleware should run first.
+type CORSConfig struct {
+	origins          []string
+	methods          []string
+	headers          []string
+	exposedHeaders   []string
+	allowCredentials bool
+	maxAge           time.Duration
+	validator        CORSOriginValidator
+	errs             []error
+}
+
+// NewCORSConfig returns a CORSConfig allowing the common methods, the Origin, Content-Type,
+// Accept and Authorization headers, and exposing the provenance and request ID headers. No
+// origins are allowed until added.
+func NewCORSConfig() *CORSConfig {
+	return &CORSConfig{
+		methods: []string{
+			http.MethodGet,
+			http.MethodHead,
+			http.MethodPost,
+			http.MethodPut,
+			http.MethodPatch,
+			http.MethodDelete,
+		},
+		headers: []string{
 			echo.HeaderOrigin,
 			echo.HeaderContentType,
 			echo.HeaderAccept,
 			echo.HeaderAuthorization,
 		},
-	})
+		exposedHeaders: []string{
+			ProvenanceIDHeader,
+			RequestIDHeader,
+		},
+	}
+}
+
+// WithOrigins adds allowed origins, ignoring surrounding whitespace and empty values
+func (cfg *CORSConfig) WithOrigins(origins ...string) *CORSConfig {
+	for _, origin := range origins {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			cfg.origins = append(cfg.origins, origin)
+		}
+	}
+
+	return cfg
+}
+
+// WithOriginsFromEnv adds the comma separated allowed origins in the environment variable name,
+// which must be set
+func (cfg *CORSConfig) WithOriginsFromEnv(name string) *CORSConfig {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		cfg.errs = append(cfg.errs, errors.Wrapf(ErrNoCORSOrigins, "$%v is not set", name))
+		return cfg
+	}
+
+	return cfg.WithOrigins(strings.Split(value, ",")...)
+}
+
+// WithMethods replaces the allowed methods
+func (cfg *CORSConfig) WithMethods(methods ...string) *CORSConfig {
+	cfg.methods = nil
+
+	for _, method := range methods {
+		cfg.methods = append(cfg.methods, strings.ToUpper(strings.TrimSpace(method)))
+	}
+
+	return cfg
+}
+
+// WithHeaders adds allowed request headers
+func (cfg *CORSConfig) WithHeaders(headers ...string) *CORSConfig {
+	cfg.headers = append(cfg.headers, headers...)
+	return cfg
+}
+
+// WithExposedHeaders adds response headers browsers let scripts read
+func (cfg *CORSConfig) WithExposedHeaders(headers ...string) *CORSConfig {
+	cfg.exposedHeaders = append(cfg.exposedHeaders, headers...)
+	return cfg
+}
+
+// WithCredentials allows cookies and Authorization headers to be sent cross-origin
+func (cfg *CORSConfig) WithCredentials() *CORSConfig {
+	cfg.allowCredentials = true
+	return cfg
+}
+
+// WithMaxAge sets how long browsers may cache preflight responses
+func (cfg *CORSConfig) WithMaxAge(maxAge time.Duration) *CORSConfig {
+	cfg.maxAge = maxAge
+	return cfg
+}
+
+// WithOriginValidator looks up origins not allowed by WithOrigins per request, ie: from the
+// domains of the request's tenant
+func (cfg *CORSConfig) WithOriginValidator(validator CORSOriginValidator) *CORSConfig {
+	cfg.validator = validator
+	return cfg
+}
+
+// allowsAnyOrigin indicates whether the "*" origin was added
+func (cfg *CORSConfig) allowsAnyOrigin() bool {
+	for _, origin := range cfg.origins {
+		if origin == corsAllOrigins {
+			return true
+		}
+	}
+
+	return false
+}
+
+// corsOrigin is a compiled allowed origin
+type corsOrigin struct {
+	scheme string
+	// host, including any port; for wildcards the suffix subdomains must end with, ie:
+	// ".example.com"
+	host     string
+	wildcard bool
+}
+
+// Middleware validates the configuration and returns the CORS middleware
+func (cfg *CORSConfig) Middleware() (echo.MiddlewareFunc, error) {
+	if len(cfg.errs) > 0 {
+		return nil, cfg.errs[0]
+	}
+
+	mw := &corsMiddleware{
+		validator:        cfg.validator,
+		allowCredentials: cfg.allowCredentials,
+		allowMethods:     strings.Join(cfg.methods, ","),
+		allowHeaders:     strings.Join(cfg.headers, ","),
+		exposeHeaders:    strings.Join(cfg.exposedHeaders, ","),
+	}
+
+	if cfg.maxAge < 0 {
+		return nil, errors.Wrapf(ErrInvalidCORSConfig, "max age %v", cfg.maxAge)
+	}
+
+	if cfg.maxAge > 0 {
+		mw.maxAge = strconv.Itoa(int(cfg.maxAge.Seconds()))
+	}
+
+	for _, method := range cfg.methods {
+		if method == "" || strings.ContainsAny(method, " ,") {
+			return nil, errors.Wrapf(ErrInvalidCORSConfig, "method %q", method)
+		}
+	}
+
+	for _, origin := range cfg.origins {
+		if origin == corsAllOrigins {
+			// browsers refuse credentialed responses allowing any origin
+			if cfg.allowCredentials {
+				return nil, errors.Wrap(ErrInvalidCORSConfig, "\"*\" origin can't allow credentials")
+			}
+
+			mw.allowAll = true
+
+			continue
+		}
+
+		compiled, err := compileCORSOrigin(origin)
+		if err != nil {
+			return nil, err
+		}
+
+		mw.origins = append(mw.origins, compiled)
+	}
+
+	return mw.Handler, nil
+}
+
+func compileCORSOrigin(origin string) (corsOrigin, error) {
+	invalid := errors.Wrapf(ErrInvalidCORSConfig, "origin %q", origin)
+
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return corsOrigin{}, invalid
+	}
+
+	if u.Path != "" && u.Path != "/" {
+		return corsOrigin{}, invalid
+	}
+
+	compiled := corsOrigin{scheme: u.Scheme, host: u.Host}
+
+	if strings.HasPrefix(u.Host, "*.") {
+		compiled.wildcard = true
+		compiled.host = u.Host[1:]
+	}
+
+	// wildcards are only allowed as the leftmost label, and not for a top level domain
+	if strings.Contains(compiled.host, "*") || (compiled.wildcard && !strings.Contains(compiled.host[1:], ".")) {
+		return corsOrigin{}, invalid
+	}
+
+	return compiled, nil
+}
+
+// matches indicates whether the lower cased origin scheme and host are allowed by o
+func (o corsOrigin) matches(scheme, host string) bool {
+	if o.scheme != scheme {
+		return false
+	}
+
+	return o.host == host || (o.wildcard && len(host) > len(o.host) && strings.HasSuffix(host, o.host))
+}
+
+// parseCORSOrigin returns the lower cased scheme and host of an Origin header value
+func parseCORSOrigin(origin string) (scheme, host string, ok bool) {
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", "", false
+	}
+
+	return u.Scheme, u.Host, true
+}
+
+// corsMiddleware implements CORS with CORSConfig's configuration
+type corsMiddleware struct {
+	origins          []corsOrigin
+	validator        CORSOriginValidator
+	allowAll         bool
+	allowCredentials bool
+	allowMethods     string
+	allowHeaders     string
+	exposeHeaders    string
+	maxAge           string
+}
+
+// allowed indicates whether the request Origin header value is allowed
+func (mw *corsMiddleware) allowed(c echo.Context, origin string) (bool, error) {
+	if mw.allowAll {
+		return true, nil
+	}
+
+	scheme, host, ok := parseCORSOrigin(origin)
+	if !ok {
+		return false, nil
+	}
+
+	for _, o := range mw.origins {
+		if o.matches(scheme, host) {
+			return true, nil
+		}
+	}
+
+	if mw.validator == nil {
+		return false, nil
+	}
+
+	return mw.validator(c, origin)
+}
+
+// logCORSRejection logs origins that aren't allowed, so misconfigured tenants can be diagnosed
+func logCORSRejection(c echo.Context, origin string, err error) {
+	fields := logFields(c.Request().Context())
+	fields["origin"] = origin
+	fields["method"] = c.Request().Method
+	fields["uri"] = c.Request().RequestURI
+
+	if err != nil {
+		logger.WithFields(fields).Error(errors.Wrap(err, "cors origin validation failed"))
+		return
+	}
+
+	logger.WithFields(fields).Warn("cors origin rejected")
+}
+
+func (mw *corsMiddleware) Handler(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		header := c.Response().Header()
+		origin := req.Header.Get(echo.HeaderOrigin)
+		preflight := req.Method == http.MethodOptions && req.Header.Get(echo.HeaderAccessControlRequestMethod) != ""
+
+		// responses differ by origin, so caches must not share them
+		header.Add(echo.HeaderVary, echo.HeaderOrigin)
+
+		if origin == "" {
+			return next(c)
+		}
+
+		allowed, err := mw.allowed(c, origin)
+		if !allowed {
+			logCORSRejection(c, origin, err)
+
+			if preflight {
+				return c.NoContent(http.StatusNoContent)
+			}
+
+			return next(c)
+		}
+
+		if mw.allowAll && !mw.allowCredentials {
+			header.Set(echo.HeaderAccessControlAllowOrigin, corsAllOrigins)
+		} else {
+			header.Set(echo.HeaderAccessControlAllowOrigin, origin)
+		}
+
+		if mw.allowCredentials {
+			header.Set(echo.HeaderAccessControlAllowCredentials, "true")
+		}
+
+		if !preflight {
+			if mw.exposeHeaders != "" {
+				header.Set(echo.HeaderAccessControlExposeHeaders, mw.exposeHeaders)
+			}
+
+			return next(c)
+		}
+
+		header.Add(echo.HeaderVary, echo.HeaderAccessControlRequestMethod)
+		header.Add(echo.HeaderVary, echo.HeaderAccessControlRequestHeaders)
+		header.Set(echo.HeaderAccessControlAllowMethods, mw.allowMethods)
+		header.Set(echo.HeaderAccessControlAllowHeaders, mw.allowHeaders)
+
+		if mw.maxAge != "" {
+			header.Set(echo.HeaderAccessControlMaxAge, mw.maxAge)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
 }
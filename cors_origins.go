@@ -0,0 +1,173 @@
+package webutils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cyberhorsey/errors"
+	echo "github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCORSOriginCacheTTL = time.Minute
+	maxCORSOriginCacheEntries = 10000
+)
+
+// CORSOriginValidator decides per request whether origin may make cross-origin requests. Errors
+// are logged and the origin rejected.
+type CORSOriginValidator func(c echo.Context, origin string) (bool, error)
+
+// CORSOriginStore looks up the origins allowed for a tenant, ie: the domains white-label customers
+// have registered, in the formats CORSConfig.WithOrigins accepts
+type CORSOriginStore interface {
+	AllowedOrigins(ctx context.Context, tenantID string) ([]string, error)
+}
+
+// CORSOriginStoreOpts contains the options for NewCORSOriginStoreValidator
+type CORSOriginStoreOpts struct {
+	Store CORSOriginStore
+	// TenantFunc returns the tenant whose origins a request may use. Defaults to the request's Host,
+	// as CORS runs before authentication and preflight requests carry no credentials; a client
+	// chosen value such as a tenant header would let any origin pick a tenant allowing it.
+	TenantFunc func(c echo.Context) string
+	// CacheTTL is how long a tenant's origins are cached. Defaults to one minute.
+	CacheTTL time.Duration
+}
+
+// corsOriginCache caches the compiled origins of tenants
+type corsOriginCache struct {
+	store      CORSOriginStore
+	tenantFunc func(c echo.Context) string
+	ttl        time.Duration
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]corsOriginEntry
+}
+
+// corsOriginEntry are the cached origins of a tenant
+type corsOriginEntry struct {
+	origins   []corsOrigin
+	expiresAt time.Time
+}
+
+// NewCORSOriginStoreValidator returns a CORSOriginValidator allowing the origins opts.Store holds
+// for the request's tenant, for use with CORSConfig.WithOriginValidator. Store errors aren't
+// cached, so lookups are retried on the next request.
+func NewCORSOriginStoreValidator(opts CORSOriginStoreOpts) (CORSOriginValidator, error) {
+	if opts.Store == nil {
+		return nil, ErrNoCORSOriginStore
+	}
+
+	if opts.TenantFunc == nil {
+		opts.TenantFunc = func(c echo.Context) string { return c.Request().Host }
+	}
+
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = defaultCORSOriginCacheTTL
+	}
+
+	cache := &corsOriginCache{
+		store:      opts.Store,
+		tenantFunc: opts.TenantFunc,
+		ttl:        opts.CacheTTL,
+		now:        time.Now,
+		entries:    make(map[string]corsOriginEntry),
+	}
+
+	return cache.Validate, nil
+}
+
+// Validate is a CORSOriginValidator
+func (cache *corsOriginCache) Validate(c echo.Context, origin string) (bool, error) {
+	scheme, host, ok := parseCORSOrigin(origin)
+	if !ok {
+		return false, nil
+	}
+
+	tenantID := cache.tenantFunc(c)
+	if tenantID == "" {
+		return false, nil
+	}
+
+	origins, err := cache.origins(c.Request().Context(), tenantID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, o := range origins {
+		if o.matches(scheme, host) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// origins returns the cached origins of tenantID, looking them up when missing or expired
+func (cache *corsOriginCache) origins(ctx context.Context, tenantID string) ([]corsOrigin, error) {
+	if origins, ok := cache.cached(tenantID); ok {
+		return origins, nil
+	}
+
+	stored, err := cache.store.AllowedOrigins(ctx, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "cache.store.AllowedOrigins")
+	}
+
+	origins := make([]corsOrigin, 0, len(stored))
+
+	for _, origin := range stored {
+		// "*" is never allowed, as one tenant's origins must not open every origin
+		compiled, err := compileCORSOrigin(origin)
+		if err != nil {
+			logger.WithFields(logrus.Fields{"tenantId": tenantID}).Error(err)
+			continue
+		}
+
+		origins = append(origins, compiled)
+	}
+
+	cache.put(tenantID, origins)
+
+	return origins, nil
+}
+
+func (cache *corsOriginCache) cached(tenantID string) ([]corsOrigin, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, ok := cache.entries[tenantID]
+	if !ok {
+		return nil, false
+	}
+
+	if !cache.now().Before(entry.expiresAt) {
+		delete(cache.entries, tenantID)
+		return nil, false
+	}
+
+	return entry.origins, true
+}
+
+func (cache *corsOriginCache) put(tenantID string, origins []corsOrigin) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	// evict expired entries before the cache grows unbounded
+	if len(cache.entries) >= maxCORSOriginCacheEntries {
+		now := cache.now()
+
+		for k, v := range cache.entries {
+			if !now.Before(v.expiresAt) {
+				delete(cache.entries, k)
+			}
+		}
+	}
+
+	if len(cache.entries) < maxCORSOriginCacheEntries {
+		cache.entries[tenantID] = corsOriginEntry{origins: origins, expiresAt: cache.now().Add(cache.ttl)}
+	}
+}
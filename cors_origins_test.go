@@ -0,0 +1,148 @@
+package webutils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCORSOriginStore struct {
+	origins map[string][]string
+	err     error
+	lookups int
+}
+
+func (s *testCORSOriginStore) AllowedOrigins(ctx context.Context, tenantID string) ([]string, error) {
+	s.lookups++
+	return s.origins[tenantID], s.err
+}
+
+func Test_NewCORSOriginStoreValidator(t *testing.T) {
+	_, err := NewCORSOriginStoreValidator(CORSOriginStoreOpts{})
+	assert.Equal(t, ErrNoCORSOriginStore, err)
+
+	store := &testCORSOriginStore{origins: map[string][]string{
+		"api.acme.com":   {"https://acme.com", "https://*.acme.com", "*", "not an origin"},
+		"api.globex.com": {"https://globex.com"},
+	}}
+
+	validate, err := NewCORSOriginStoreValidator(CORSOriginStoreOpts{Store: store, CacheTTL: time.Hour})
+	assert.Nil(t, err)
+
+	mw, err := NewCORSConfig().WithOrigins("https://admin.example.com").WithOriginValidator(validate).Middleware()
+	assert.Nil(t, err)
+
+	e := echo.New()
+	e.Use(ProvenanceIDMiddleware, mw)
+	e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	serve := func(host, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = host
+		req.Header.Set(echo.HeaderOrigin, origin)
+		req.Header.Set(ProvenanceIDHeader, "pid-1")
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	tests := []struct {
+		name      string
+		host      string
+		origin    string
+		wantAllow bool
+	}{
+		{"static origin", "api.acme.com", "https://admin.example.com", true},
+		{"tenant origin", "api.acme.com", "https://acme.com", true},
+		{"tenant subdomain", "api.acme.com", "https://shop.acme.com", true},
+		{"other tenant's origin", "api.acme.com", "https://globex.com", false},
+		{"stored \"*\" is ignored", "api.acme.com", "https://evil.com", false},
+		{"other tenant", "api.globex.com", "https://globex.com", true},
+		{"unknown tenant", "api.initech.com", "https://initech.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.host, tt.origin)
+			assert.Equal(t, echo.HeaderOrigin, rec.Header().Get(echo.HeaderVary))
+
+			if tt.wantAllow {
+				assert.Equal(t, tt.origin, rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+			} else {
+				assert.Equal(t, "", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+			}
+		})
+	}
+
+	// each tenant is looked up once while cached
+	assert.Equal(t, 3, store.lookups)
+
+	// rejections are logged with the provenance id
+	out := logger.Out
+	buf := &bytes.Buffer{}
+	logger.SetOutput(buf)
+
+	defer logger.SetOutput(out)
+
+	serve("api.acme.com", "https://evil.com")
+	assert.Contains(t, buf.String(), `"provenanceId":"pid-1"`)
+	assert.Contains(t, buf.String(), `"origin":"https://evil.com"`)
+}
+
+func Test_corsOriginCache(t *testing.T) {
+	store := &testCORSOriginStore{
+		origins: map[string][]string{"acme": {"https://acme.com"}},
+		err:     errors.New("store unavailable"),
+	}
+
+	validate, err := NewCORSOriginStoreValidator(CORSOriginStoreOpts{
+		Store:      store,
+		TenantFunc: func(c echo.Context) string { return c.Request().Header.Get(HeaderTenantID) },
+	})
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(HeaderTenantID, "acme")
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+
+	// errors reject the origin and aren't cached
+	allowed, err := validate(c, "https://acme.com")
+	assert.NotNil(t, err)
+	assert.False(t, allowed)
+
+	store.err = nil
+
+	allowed, err = validate(c, "https://acme.com")
+	assert.Nil(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, 2, store.lookups)
+
+	// origins are looked up again once expired
+	now := time.Now()
+	cache := &corsOriginCache{
+		store:      store,
+		tenantFunc: func(c echo.Context) string { return "acme" },
+		ttl:        time.Minute,
+		now:        func() time.Time { return now },
+		entries:    make(map[string]corsOriginEntry),
+	}
+
+	_, _ = cache.Validate(c, "https://acme.com")
+	_, _ = cache.Validate(c, "https://acme.com")
+	assert.Equal(t, 3, store.lookups)
+
+	now = now.Add(2 * time.Minute)
+
+	allowed, _ = cache.Validate(c, "https://acme.com")
+	assert.True(t, allowed)
+	assert.Equal(t, 4, store.lookups)
+}
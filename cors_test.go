@@ -1,8 +1,15 @@
 package webutils
 
 import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
 )
 
 func Test_ConfigureCORSMiddleware(t *testing.T) {
@@ -40,3 +47,194 @@ func Test_ConfigureCORSMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func Test_ConfigureCORSMiddleware_AnyOrigin(t *testing.T) {
+	e := echo.New()
+	e.Use(ConfigureCORSMiddleware([]string{"*"}))
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderOrigin, "https://anywhere.example.com")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	// "*" keeps allowing any origin, without credentials
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "*", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	assert.Empty(t, rec.Header().Get(echo.HeaderAccessControlAllowCredentials))
+}
+
+func Test_ConfigureStrictCORSMiddleware(t *testing.T) {
+	_, err := ConfigureStrictCORSMiddleware([]string{"*"})
+	assert.True(t, errors.Is(err, ErrInvalidCORSConfig))
+
+	env, ok := os.LookupEnv("CORS_DOMAINS")
+	defer func() {
+		if ok {
+			os.Setenv("CORS_DOMAINS", env)
+		} else {
+			os.Unsetenv("CORS_DOMAINS")
+		}
+	}()
+
+	os.Unsetenv("CORS_DOMAINS")
+
+	_, err = ConfigureStrictCORSMiddleware(nil)
+	assert.True(t, errors.Is(err, ErrNoCORSOrigins))
+
+	os.Setenv("CORS_DOMAINS", "https://app.example.com, https://*.example.com")
+
+	mw, err := ConfigureStrictCORSMiddleware(nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, mw)
+}
+
+func Test_CORSConfig_Middleware_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     func() *CORSConfig
+		wantErr error
+	}{
+		{"valid", func() *CORSConfig {
+			return NewCORSConfig().WithOrigins("https://example.com", " https://*.example.com:8443 ").WithCredentials()
+		}, nil},
+		{"any origin", func() *CORSConfig { return NewCORSConfig().WithOrigins("*") }, nil},
+		{"any origin with credentials", func() *CORSConfig {
+			return NewCORSConfig().WithOrigins("*").WithCredentials()
+		}, ErrInvalidCORSConfig},
+		{"no scheme", func() *CORSConfig { return NewCORSConfig().WithOrigins("example.com") }, ErrInvalidCORSConfig},
+		{"path", func() *CORSConfig { return NewCORSConfig().WithOrigins("https://example.com/app") }, ErrInvalidCORSConfig},
+		{"inner wildcard", func() *CORSConfig {
+			return NewCORSConfig().WithOrigins("https://api.*.example.com")
+		}, ErrInvalidCORSConfig},
+		{"top level wildcard", func() *CORSConfig {
+			return NewCORSConfig().WithOrigins("https://*.com")
+		}, ErrInvalidCORSConfig},
+		{"negative max age", func() *CORSConfig {
+			return NewCORSConfig().WithOrigins("https://example.com").WithMaxAge(-time.Second)
+		}, ErrInvalidCORSConfig},
+		{"bad method", func() *CORSConfig {
+			return NewCORSConfig().WithOrigins("https://example.com").WithMethods("GET,POST")
+		}, ErrInvalidCORSConfig},
+		{"unset env", func() *CORSConfig {
+			os.Unsetenv("TEST_CORS_DOMAINS")
+			return NewCORSConfig().WithOriginsFromEnv("TEST_CORS_DOMAINS")
+		}, ErrNoCORSOrigins},
+		{"bad env", func() *CORSConfig {
+			os.Setenv("TEST_CORS_DOMAINS", "https://example.com, localhost:8004")
+			return NewCORSConfig().WithOriginsFromEnv("TEST_CORS_DOMAINS")
+		}, ErrInvalidCORSConfig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mw, err := tt.cfg().Middleware()
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+				assert.NotNil(t, mw)
+
+				return
+			}
+
+			assert.Nil(t, mw)
+			assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+		})
+	}
+}
+
+func Test_CORSConfig_Middleware(t *testing.T) {
+	mw, err := NewCORSConfig().
+		WithOrigins("https://example.com", "https://*.example.org").
+		WithHeaders("X-Custom").
+		WithExposedHeaders("X-Total-Count").
+		WithCredentials().
+		WithMaxAge(10 * time.Minute).
+		Middleware()
+	assert.Nil(t, err)
+
+	e := echo.New()
+	e.Use(mw)
+	e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	serve := func(method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		if origin != "" {
+			req.Header.Set(echo.HeaderOrigin, origin)
+		}
+
+		if method == http.MethodOptions {
+			req.Header.Set(echo.HeaderAccessControlRequestMethod, http.MethodGet)
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	tests := []struct {
+		name      string
+		method    string
+		origin    string
+		wantAllow string
+	}{
+		{"no origin", http.MethodGet, "", ""},
+		{"exact", http.MethodGet, "https://example.com", "https://example.com"},
+		{"subdomain", http.MethodGet, "https://app.example.org", "https://app.example.org"},
+		{"nested subdomain", http.MethodGet, "https://a.b.example.org", "https://a.b.example.org"},
+		{"wildcard base domain", http.MethodGet, "https://example.org", ""},
+		{"suffix lookalike", http.MethodGet, "https://evilexample.org", ""},
+		{"scheme mismatch", http.MethodGet, "http://example.com", ""},
+		{"preflight", http.MethodOptions, "https://example.com", "https://example.com"},
+		{"rejected preflight", http.MethodOptions, "https://evil.com", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.method, tt.origin)
+			header := rec.Header()
+
+			assert.Contains(t, header.Values(echo.HeaderVary), echo.HeaderOrigin)
+			assert.Equal(t, tt.wantAllow, header.Get(echo.HeaderAccessControlAllowOrigin))
+
+			if tt.method == http.MethodOptions {
+				assert.Equal(t, http.StatusNoContent, rec.Code)
+			} else {
+				assert.Equal(t, http.StatusOK, rec.Code)
+			}
+
+			if tt.wantAllow == "" {
+				assert.Equal(t, "", header.Get(echo.HeaderAccessControlAllowCredentials))
+				return
+			}
+
+			assert.Equal(t, "true", header.Get(echo.HeaderAccessControlAllowCredentials))
+
+			if tt.method == http.MethodOptions {
+				assert.Equal(t, "600", header.Get(echo.HeaderAccessControlMaxAge))
+				assert.Contains(t, header.Get(echo.HeaderAccessControlAllowHeaders), "X-Custom")
+				assert.Contains(t, header.Get(echo.HeaderAccessControlAllowMethods), http.MethodPatch)
+			} else {
+				exposed := header.Get(echo.HeaderAccessControlExposeHeaders)
+				assert.Contains(t, exposed, RequestIDHeader)
+				assert.Contains(t, exposed, ProvenanceIDHeader)
+				assert.Contains(t, exposed, "X-Total-Count")
+			}
+		})
+	}
+
+	// any origin without credentials uses the "*" wildcard
+	mw, err = NewCORSConfig().WithOrigins("*").Middleware()
+	assert.Nil(t, err)
+
+	e = echo.New()
+	e.Use(mw)
+	e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	rec := serve(http.MethodGet, "https://anywhere.com")
+	assert.Equal(t, "*", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	assert.Equal(t, "", rec.Header().Get(echo.HeaderAccessControlAllowCredentials))
+}
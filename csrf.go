@@ -0,0 +1,153 @@
+package webutils
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/cyberhorsey/errors"
+	echo "github.com/labstack/echo/v4"
+)
+
+const (
+	// HeaderCSRFToken is the default header state-changing requests send the CSRF token in
+	HeaderCSRFToken = "X-CSRF-Token"
+
+	defaultCSRFCookieName = "_csrf"
+	defaultCSRFFormField  = "_csrf"
+	csrfTokenLength       = 32
+
+	// contextKeyCSRFToken is the echo.Context key of the request's CSRF token
+	contextKeyCSRFToken = "csrf-token"
+)
+
+// CSRFMiddlewareOpts contains the options for ConfigureCSRFMiddleware
+type CSRFMiddlewareOpts struct {
+	Skipper func(c echo.Context) bool
+	// CookieName defaults to "_csrf"
+	CookieName string
+	Domain     string
+	// Path defaults to "/"
+	Path string
+	// SameSite defaults to http.SameSiteStrictMode
+	SameSite http.SameSite
+	// Insecure omits the Secure cookie attribute, for local development over http only
+	Insecure bool
+	// Header the token is read from. Defaults to HeaderCSRFToken.
+	Header string
+	// FormField the token is read from when the header is absent. Defaults to "_csrf".
+	FormField string
+}
+
+// csrfMiddleware implements double-submit cookie CSRF protection
+type csrfMiddleware struct {
+	Skipper    func(c echo.Context) bool
+	CookieName string
+	Domain     string
+	Path       string
+	SameSite   http.SameSite
+	Insecure   bool
+	Header     string
+	FormField  string
+}
+
+// ConfigureCSRFMiddleware configures double-submit cookie CSRF protection. A random token is set
+// in a cookie, and state-changing requests must echo it in a header or form field; pages can
+// embed it from CSRFTokenFromContext. Requests without a matching token are rejected with a 403.
+func ConfigureCSRFMiddleware(opts CSRFMiddlewareOpts) echo.MiddlewareFunc {
+	mw := csrfMiddleware(opts)
+	if mw.Skipper == nil {
+		mw.Skipper = func(c echo.Context) bool { return false }
+	}
+
+	if mw.CookieName == "" {
+		mw.CookieName = defaultCSRFCookieName
+	}
+
+	if mw.Path == "" {
+		mw.Path = "/"
+	}
+
+	if mw.SameSite == 0 {
+		mw.SameSite = http.SameSiteStrictMode
+	}
+
+	if mw.Header == "" {
+		mw.Header = HeaderCSRFToken
+	}
+
+	if mw.FormField == "" {
+		mw.FormField = defaultCSRFFormField
+	}
+
+	return mw.Handler
+}
+
+func (mw *csrfMiddleware) Handler(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if mw.Skipper(c) {
+			return next(c)
+		}
+
+		var token string
+
+		if cookie, err := c.Cookie(mw.CookieName); err == nil && len(cookie.Value) == csrfTokenLength {
+			token = cookie.Value
+		} else {
+			t, err := SecureRandomString(csrfTokenLength)
+			if err != nil {
+				return LogAndRenderUnexpectedError(c, errors.Wrap(err, "SecureRandomString"))
+			}
+
+			token = t
+
+			c.SetCookie(&http.Cookie{
+				Name:   mw.CookieName,
+				Value:  token,
+				Path:   mw.Path,
+				Domain: mw.Domain,
+				Secure: !mw.Insecure,
+				// readable by JavaScript, which has to echo it in the header
+				HttpOnly: false,
+				SameSite: mw.SameSite,
+			})
+		}
+
+		c.Set(contextKeyCSRFToken, token)
+
+		switch c.Request().Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			return next(c)
+		}
+
+		submitted := c.Request().Header.Get(mw.Header)
+		if submitted == "" {
+			submitted = c.FormValue(mw.FormField)
+		}
+
+		if submitted == "" {
+			return LogAndRenderErrors(c, http.StatusForbidden, ErrCSRFTokenRequired)
+		}
+
+		if subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+			return LogAndRenderErrors(c, http.StatusForbidden, ErrCSRFTokenInvalid)
+		}
+
+		return next(c)
+	}
+}
+
+// CSRFTokenFromContext returns the CSRF token for the request, to embed in pages and forms
+func CSRFTokenFromContext(c echo.Context) string {
+	token, _ := c.Get(contextKeyCSRFToken).(string)
+	return token
+}
+
+// CSRFTokenFromRequest is CSRFTokenFromContext for net/http handlers behind the adapted middleware
+func CSRFTokenFromRequest(r *http.Request) string {
+	c, ok := echoContextFromRequest(r)
+	if !ok {
+		return ""
+	}
+
+	return CSRFTokenFromContext(c)
+}
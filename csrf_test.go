@@ -0,0 +1,86 @@
+package webutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CSRFMiddleware(t *testing.T) {
+	e := echo.New()
+	e.Use(ConfigureCSRFMiddleware(CSRFMiddlewareOpts{}))
+
+	e.GET("/form", func(c echo.Context) error {
+		return c.String(http.StatusOK, CSRFTokenFromContext(c))
+	})
+	e.POST("/form", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/form", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	cookies := rec.Result().Cookies()
+	assert.Len(t, cookies, 1)
+
+	cookie := cookies[0]
+	assert.Equal(t, defaultCSRFCookieName, cookie.Name)
+	assert.Equal(t, rec.Body.String(), cookie.Value)
+	assert.True(t, cookie.Secure)
+	assert.False(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+
+	token := cookie.Value
+
+	tests := []struct {
+		name       string
+		cookie     bool
+		header     string
+		form       string
+		wantStatus int
+		wantKey    string
+	}{
+		{"header", true, token, "", http.StatusNoContent, ""},
+		{"form field", true, "", token, http.StatusNoContent, ""},
+		{"missing token", true, "", "", http.StatusForbidden, "ERR_CSRF_TOKEN_REQUIRED"},
+		{"wrong token", true, strings.Repeat("a", csrfTokenLength), "", http.StatusForbidden, "ERR_CSRF_TOKEN_INVALID"},
+		{"no cookie", false, token, "", http.StatusForbidden, "ERR_CSRF_TOKEN_INVALID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.form != "" {
+				form.Set(defaultCSRFFormField, tt.form)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/form", strings.NewReader(form.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+
+			if tt.cookie {
+				req.AddCookie(cookie)
+			}
+
+			if tt.header != "" {
+				req.Header.Set(HeaderCSRFToken, tt.header)
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+
+			if tt.wantKey != "" {
+				errResp := &ErrorResponse{}
+				assert.Nil(t, errResp.UnmarshalJSON(rec.Body.Bytes()))
+				assert.Equal(t, tt.wantKey, errResp.Errors[0].Key)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package webutils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyberhorsey/errors"
+	jwt "github.com/golang-jwt/jwt/v4"
+	echo "github.com/labstack/echo/v4"
+)
+
+const (
+	// HeaderDPoP is the request header carrying an RFC 9449 DPoP proof
+	HeaderDPoP = "DPoP"
+
+	dpopPrefix    = `DPoP `
+	dpopProofType = "dpop+jwt"
+
+	defaultDPoPMaxAge    = time.Minute
+	defaultDPoPClockSkew = 5 * time.Second
+	dpopReplayPruneEvery = time.Minute
+)
+
+// defaultDPoPSigningMethods are the asymmetric methods accepted for DPoP proofs by default
+var defaultDPoPSigningMethods = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+}
+
+// Confirmation is the RFC 7800 cnf claim, binding a token to a key
+type Confirmation struct {
+	// JKT is the JWK thumbprint of the key DPoP proofs for the token must be signed with
+	JKT string `json:"jkt,omitempty"`
+}
+
+// BindDPoPKey binds the claims to the client's DPoP key, so a token created from them by
+// CreateJWT is only accepted alongside a proof signed with that key.
+func (c *Claims) BindDPoPKey(key JWK) error {
+	jkt, err := key.Thumbprint()
+	if err != nil {
+		return errors.Wrap(err, "key.Thumbprint")
+	}
+
+	c.Confirmation = &Confirmation{JKT: jkt}
+
+	return nil
+}
+
+// DPoPReplayCache remembers the proofs already presented, so each can only be used once
+type DPoPReplayCache interface {
+	// Seen records the jti until expiresAt, indicating whether it had already been recorded
+	Seen(ctx context.Context, jti string, expiresAt time.Time) (bool, error)
+}
+
+// InMemoryDPoPReplayCache is a DPoPReplayCache for a single instance; deployments with several
+// instances need a shared cache so a proof can't be replayed against another instance.
+type InMemoryDPoPReplayCache struct {
+	mu       sync.Mutex
+	jtis     map[string]time.Time
+	now      func() time.Time
+	prunedAt time.Time
+}
+
+// NewInMemoryDPoPReplayCache creates an empty InMemoryDPoPReplayCache
+func NewInMemoryDPoPReplayCache() *InMemoryDPoPReplayCache {
+	return &InMemoryDPoPReplayCache{
+		jtis: make(map[string]time.Time),
+		now:  time.Now,
+	}
+}
+
+// Seen implements DPoPReplayCache
+func (r *InMemoryDPoPReplayCache) Seen(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+
+	if now.Sub(r.prunedAt) >= dpopReplayPruneEvery {
+		for k, exp := range r.jtis {
+			if !now.Before(exp) {
+				delete(r.jtis, k)
+			}
+		}
+
+		r.prunedAt = now
+	}
+
+	if exp, ok := r.jtis[jti]; ok && now.Before(exp) {
+		return true, nil
+	}
+
+	r.jtis[jti] = expiresAt
+
+	return false, nil
+}
+
+// DPoPVerifierOpts contains the options for NewDPoPVerifier
+type DPoPVerifierOpts struct {
+	// ReplayCache rejects reused proofs. Defaults to an InMemoryDPoPReplayCache.
+	ReplayCache DPoPReplayCache
+	// MaxAge is how long after its iat a proof is accepted. Defaults to one minute.
+	MaxAge time.Duration
+	// ClockSkew tolerated for proofs with an iat in the future. Defaults to 5 seconds.
+	ClockSkew time.Duration
+	// SigningMethods accepted for proofs. Defaults to the RSA, RSA-PSS and ECDSA methods.
+	SigningMethods []string
+}
+
+// DPoPVerifier verifies RFC 9449 DPoP proofs
+type DPoPVerifier struct {
+	opts DPoPVerifierOpts
+	now  func() time.Time
+}
+
+// NewDPoPVerifier creates a DPoPVerifier, for use as JWTMiddlewareOpts.DPoP
+func NewDPoPVerifier(opts DPoPVerifierOpts) *DPoPVerifier {
+	if opts.ReplayCache == nil {
+		opts.ReplayCache = NewInMemoryDPoPReplayCache()
+	}
+
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = defaultDPoPMaxAge
+	}
+
+	if opts.ClockSkew <= 0 {
+		opts.ClockSkew = defaultDPoPClockSkew
+	}
+
+	if len(opts.SigningMethods) == 0 {
+		opts.SigningMethods = defaultDPoPSigningMethods
+	}
+
+	return &DPoPVerifier{
+		opts: opts,
+		now:  time.Now,
+	}
+}
+
+// DPoPProofRequest describes the request a DPoP proof was presented with
+type DPoPProofRequest struct {
+	Method string
+	// URL of the request; its query and fragment are ignored
+	URL string
+	// AccessToken presented with the proof, if any, which the proof's ath claim must hash
+	AccessToken string
+}
+
+// dpopProofClaims are the claims of a DPoP proof, validated by Verify rather than the parser
+type dpopProofClaims struct {
+	ID       string `json:"jti"`
+	IssuedAt int64  `json:"iat"`
+	HTM      string `json:"htm"`
+	HTU      string `json:"htu"`
+	ATH      string `json:"ath,omitempty"`
+}
+
+func (c *dpopProofClaims) Valid() error {
+	return nil
+}
+
+// Verify verifies the proof was signed by the key in its header for req, and has not been
+// presented before. It returns the thumbprint of the key, to compare with a token's cnf claim.
+func (v *DPoPVerifier) Verify(ctx context.Context, proof string, req DPoPProofRequest) (string, error) {
+	var key JWK
+
+	claims := &dpopProofClaims{}
+
+	parser := jwt.NewParser(jwt.WithValidMethods(v.opts.SigningMethods))
+
+	_, err := parser.ParseWithClaims(proof, claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != dpopProofType {
+			return nil, errors.Wrapf(ErrInvalidDPoPProof, "typ %q", typ)
+		}
+
+		k, err := dpopProofKey(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+
+		key = k
+
+		return key.PublicKey()
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if claims.ID == "" {
+		return "", errors.Wrap(ErrInvalidDPoPProof, "jti is required")
+	}
+
+	if claims.HTM != req.Method {
+		return "", errors.Wrapf(ErrInvalidDPoPProof, "htm %q", claims.HTM)
+	}
+
+	if !dpopURLsMatch(claims.HTU, req.URL) {
+		return "", errors.Wrapf(ErrInvalidDPoPProof, "htu %q", claims.HTU)
+	}
+
+	now := v.now()
+	issuedAt := time.Unix(claims.IssuedAt, 0)
+
+	if now.Sub(issuedAt) > v.opts.MaxAge || issuedAt.Sub(now) > v.opts.ClockSkew {
+		return "", ErrDPoPProofExpired
+	}
+
+	if req.AccessToken != "" && claims.ATH != dpopAccessTokenHash(req.AccessToken) {
+		return "", errors.Wrap(ErrInvalidDPoPProof, "ath does not match access token")
+	}
+
+	jkt, err := key.Thumbprint()
+	if err != nil {
+		return "", errors.Wrap(err, "key.Thumbprint")
+	}
+
+	// jtis are scoped to the key, so one client can't burn another's
+	seen, err := v.opts.ReplayCache.Seen(ctx, jkt+":"+claims.ID, issuedAt.Add(v.opts.MaxAge+v.opts.ClockSkew))
+	if err != nil {
+		return "", errors.Wrap(err, "v.opts.ReplayCache.Seen")
+	}
+
+	if seen {
+		return "", ErrDPoPProofReplayed
+	}
+
+	return jkt, nil
+}
+
+// authenticate validates a DPoP-bound access token together with the proof in the request
+func (v *DPoPVerifier) authenticate(
+	c echo.Context,
+	token string,
+	opts JWTParseOpts,
+	allowUntypedTokens bool,
+) (*Claims, string, error) {
+	claims, err := authenticateJWT(token, opts, allowUntypedTokens)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if claims.Confirmation == nil || claims.Confirmation.JKT == "" {
+		return nil, "", errors.WithCause(ErrAuthorizationTokenInvalid, ErrTokenNotDPoPBound)
+	}
+
+	// exactly one proof is allowed
+	proofs := c.Request().Header.Values(HeaderDPoP)
+	if len(proofs) != 1 {
+		return nil, "", ErrAuthorizationDPoPProofRequired
+	}
+
+	jkt, err := v.Verify(c.Request().Context(), proofs[0], DPoPProofRequest{
+		Method:      c.Request().Method,
+		URL:         c.Scheme() + "://" + c.Request().Host + c.Request().URL.EscapedPath(),
+		AccessToken: token,
+	})
+	if err != nil {
+		return nil, "", errors.WithCause(ErrAuthorizationDPoPProofInvalid, err)
+	}
+
+	if jkt != claims.Confirmation.JKT {
+		return nil, "", errors.WithCause(ErrAuthorizationDPoPProofInvalid, ErrDPoPKeyMismatch)
+	}
+
+	return claims, token, nil
+}
+
+// challenge returns the DPoP WWW-Authenticate header value for err
+func (v *DPoPVerifier) challenge(realm string, err error) string {
+	return authChallenge(
+		"DPoP",
+		realm,
+		err,
+		challengeParam("algs", strings.Join(v.opts.SigningMethods, " ")),
+	)
+}
+
+// dpopProofKey returns the public key from a proof's jwk header
+func dpopProofKey(header interface{}) (JWK, error) {
+	var key JWK
+
+	members, ok := header.(map[string]interface{})
+	if !ok {
+		return key, errors.Wrap(ErrInvalidDPoPProof, "jwk header is required")
+	}
+
+	// a private key must never be sent
+	if _, ok := members["d"]; ok {
+		return key, errors.Wrap(ErrInvalidDPoPProof, "jwk header contains a private key")
+	}
+
+	bs, err := json.Marshal(members)
+	if err != nil {
+		return key, errors.Wrap(err, "json.Marshal")
+	}
+
+	if err := json.Unmarshal(bs, &key); err != nil {
+		return key, errors.Wrap(err, "json.Unmarshal")
+	}
+
+	return key, nil
+}
+
+// dpopURLsMatch compares URLs as RFC 9449 requires, ignoring query, fragment and the case of the
+// scheme and host
+func dpopURLsMatch(htu, requestURL string) bool {
+	a, ok := normalizeDPoPURL(htu)
+	if !ok {
+		return false
+	}
+
+	b, ok := normalizeDPoPURL(requestURL)
+
+	return ok && a == b
+}
+
+func normalizeDPoPURL(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", false
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	switch {
+	case u.Scheme == "https" && u.Port() == "443", u.Scheme == "http" && u.Port() == "80":
+		u.Host = strings.TrimSuffix(u.Host, ":"+u.Port())
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	return u.String(), true
+}
+
+// dpopAccessTokenHash returns the ath claim value for token
+func dpopAccessTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
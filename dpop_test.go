@@ -0,0 +1,235 @@
+package webutils
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const testDPoPURL = "http://example.com/protected"
+
+func newTestDPoPKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey: %v", err)
+	}
+
+	return key
+}
+
+func newTestDPoPClaims(method, url, accessToken string) *dpopProofClaims {
+	jti, _ := SecureRandomHex(8)
+
+	claims := &dpopProofClaims{
+		ID:       jti,
+		IssuedAt: time.Now().Unix(),
+		HTM:      method,
+		HTU:      url,
+	}
+
+	if accessToken != "" {
+		claims.ATH = dpopAccessTokenHash(accessToken)
+	}
+
+	return claims
+}
+
+// newTestDPoPProof signs claims with key, after letting header be modified
+func newTestDPoPProof(
+	t *testing.T,
+	key *ecdsa.PrivateKey,
+	claims *dpopProofClaims,
+	header func(h map[string]interface{}),
+) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = dpopProofType
+	token.Header["jwk"] = newTestECJWK("", &key.PublicKey)
+
+	if header != nil {
+		header(token.Header)
+	}
+
+	proof, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+
+	return proof
+}
+
+func Test_DPoPVerifier_Verify(t *testing.T) {
+	key := newTestDPoPKey(t)
+
+	jkt, err := newTestECJWK("", &key.PublicKey).Thumbprint()
+	assert.Nil(t, err)
+
+	req := DPoPProofRequest{Method: http.MethodGet, URL: testDPoPURL + "?page=2", AccessToken: "token"}
+
+	tests := []struct {
+		name    string
+		claims  func(c *dpopProofClaims)
+		header  func(h map[string]interface{})
+		wantErr error
+	}{
+		{"valid", nil, nil, nil},
+		{"case insensitive host", func(c *dpopProofClaims) { c.HTU = "HTTP://Example.COM:80/protected" }, nil, nil},
+		{"wrong typ", nil, func(h map[string]interface{}) { h["typ"] = "JWT" }, ErrInvalidDPoPProof},
+		{"no jwk", nil, func(h map[string]interface{}) { delete(h, "jwk") }, ErrInvalidDPoPProof},
+		{"private jwk", nil, func(h map[string]interface{}) {
+			h["jwk"] = map[string]interface{}{"kty": "EC", "d": "secret"}
+		}, ErrInvalidDPoPProof},
+		{"no jti", func(c *dpopProofClaims) { c.ID = "" }, nil, ErrInvalidDPoPProof},
+		{"wrong method", func(c *dpopProofClaims) { c.HTM = http.MethodPost }, nil, ErrInvalidDPoPProof},
+		{"wrong url", func(c *dpopProofClaims) { c.HTU = "http://example.com/other" }, nil, ErrInvalidDPoPProof},
+		{"stale", func(c *dpopProofClaims) { c.IssuedAt -= 120 }, nil, ErrDPoPProofExpired},
+		{"future", func(c *dpopProofClaims) { c.IssuedAt += 60 }, nil, ErrDPoPProofExpired},
+		{"wrong ath", func(c *dpopProofClaims) { c.ATH = dpopAccessTokenHash("other") }, nil, ErrInvalidDPoPProof},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewDPoPVerifier(DPoPVerifierOpts{})
+
+			claims := newTestDPoPClaims(http.MethodGet, testDPoPURL, "token")
+			if tt.claims != nil {
+				tt.claims(claims)
+			}
+
+			got, err := v.Verify(context.Background(), newTestDPoPProof(t, key, claims, tt.header), req)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, jkt, got)
+		})
+	}
+}
+
+func Test_DPoPVerifier_Replay(t *testing.T) {
+	key := newTestDPoPKey(t)
+	v := NewDPoPVerifier(DPoPVerifierOpts{})
+	req := DPoPProofRequest{Method: http.MethodGet, URL: testDPoPURL}
+
+	proof := newTestDPoPProof(t, key, newTestDPoPClaims(http.MethodGet, testDPoPURL, ""), nil)
+
+	_, err := v.Verify(context.Background(), proof, req)
+	assert.Nil(t, err)
+
+	_, err = v.Verify(context.Background(), proof, req)
+	assert.Equal(t, ErrDPoPProofReplayed, err)
+}
+
+func Test_InMemoryDPoPReplayCache(t *testing.T) {
+	now := time.Now()
+	cache := NewInMemoryDPoPReplayCache()
+	cache.now = func() time.Time { return now }
+
+	seen, err := cache.Seen(context.Background(), "a", now.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.False(t, seen)
+
+	seen, _ = cache.Seen(context.Background(), "a", now.Add(time.Minute))
+	assert.True(t, seen)
+
+	// expired entries are forgotten
+	now = now.Add(2 * time.Minute)
+	seen, _ = cache.Seen(context.Background(), "a", now.Add(time.Minute))
+	assert.False(t, seen)
+	assert.Len(t, cache.jtis, 1)
+}
+
+func Test_JWTMiddleware_DPoP(t *testing.T) {
+	key := newTestDPoPKey(t)
+
+	claims := newTestClaims(JWTAccess, time.Hour)
+	assert.Nil(t, claims.BindDPoPKey(newTestECJWK("", &key.PublicKey)))
+
+	bound := newTestJWT(t, claims)
+	unbound := newTestJWT(t, newTestClaims(JWTAccess, time.Hour))
+
+	newProof := func(signer *ecdsa.PrivateKey, token string) string {
+		return newTestDPoPProof(t, signer, newTestDPoPClaims(http.MethodGet, testDPoPURL, token), nil)
+	}
+
+	mw, err := ConfigureJWTMiddleware(JWTMiddlewareOpts{
+		PublicKey: testPublicKeyFunc,
+		Realm:     "api",
+		DPoP:      NewDPoPVerifier(DPoPVerifierOpts{}),
+	})
+	assert.Nil(t, err)
+
+	bearerOnly, err := ConfigureJWTMiddleware(JWTMiddlewareOpts{PublicKey: testPublicKeyFunc})
+	assert.Nil(t, err)
+
+	serve := func(mw echo.MiddlewareFunc, authorization string, proofs ...string) *httptest.ResponseRecorder {
+		e := echo.New()
+		e.Use(mw)
+		e.GET("/protected", func(c echo.Context) error {
+			return c.NoContent(http.StatusNoContent)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+		req.Header.Set(echo.HeaderAuthorization, authorization)
+
+		for _, proof := range proofs {
+			req.Header.Add(HeaderDPoP, proof)
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	proof := newProof(key, bound)
+
+	rec := serve(mw, "DPoP "+bound, proof)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	// replayed proof
+	rec = serve(mw, "DPoP "+bound, proof)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.True(t, strings.HasPrefix(
+		rec.Header().Get(echo.HeaderWWWAuthenticate),
+		`DPoP realm="api", error="invalid_dpop_proof"`,
+	))
+
+	// proof signed by another key
+	rec = serve(mw, "DPoP "+bound, newProof(newTestDPoPKey(t), bound))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// missing and duplicate proofs
+	rec = serve(mw, "DPoP "+bound)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = serve(mw, "DPoP "+bound, newProof(key, bound), newProof(key, bound))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// bound tokens can't be used as bearer tokens
+	rec = serve(mw, "Bearer "+bound, newProof(key, bound))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "ERR_AUTHORIZATION_DPOP_PROOF_REQUIRED")
+
+	rec = serve(bearerOnly, "Bearer "+bound)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// unbound tokens can't be used with the DPoP scheme
+	rec = serve(mw, "DPoP "+unbound, newProof(key, unbound))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = serve(mw, "Bearer "+unbound)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
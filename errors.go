@@ -1,11 +1,13 @@
 package webutils
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,6 +30,10 @@ func init() {
 	})
 }
 
+// Unavailable is the qerrors.ErrorType of errors caused by a dependency being unavailable, ie: an
+// authorization server, rendered as 503 Service Unavailable. It's numbered clear of qerrors' types.
+const Unavailable qerrors.ErrorType = 100
+
 // sentinel errors
 var (
 	ErrNoClaims                  = errors.New("claims is required")
@@ -35,10 +41,63 @@ var (
 	ErrNoSecret                  = errors.New("secret is required")
 	ErrNoToken                   = errors.New("token is required")
 	ErrInvalidToken              = errors.New("jwt token not valid")
+	ErrInvalidTokenIssuer        = errors.New("jwt token issuer not valid")
+	ErrInvalidTokenAudience      = errors.New("jwt token audience not valid")
+	ErrInvalidSigningMethod      = errors.New("jwt signing method not accepted")
+	ErrInvalidJWK                = errors.New("jwk not valid")
+	ErrUnsupportedJWK            = errors.New("jwk type or curve not supported")
+	ErrUnknownJWK                = errors.New("jwk not found for token kid")
+	ErrInvalidDPoPProof          = errors.New("dpop proof not valid")
+	ErrDPoPProofExpired          = errors.New("dpop proof iat outside accepted window")
+	ErrDPoPProofReplayed         = errors.New("dpop proof already used")
+	ErrDPoPKeyMismatch           = errors.New("dpop proof key does not match token cnf")
+	ErrTokenNotDPoPBound         = errors.New("jwt token not bound to a dpop key")
+	ErrInvalidJWE                = errors.New("jwe not valid")
+	ErrUnsupportedJWE            = errors.New("jwe algorithm or content type not supported")
+	ErrUnknownJWEKey             = errors.New("jwe key not found for kid")
 	ErrNoJWTClaimsInContext      = errors.New("jwt claim missing from context")
 	ErrNoJWTInContext            = errors.New("jwt missing from context")
 	ErrNoNotificationMessage     = qerrors.New("message is required")
 	ErrNoPublicKeyFunction       = qerrors.New("public key func is required")
+	ErrNoIntrospectionEndpoint   = qerrors.New("introspection endpoint is required")
+	ErrNoIntrospectionClientID   = qerrors.New("introspection client id is required")
+	ErrNoIntrospector            = qerrors.New("introspector is required")
+	ErrNoAPIKeyStore             = qerrors.New("api key store is required")
+	ErrInvalidAPIKeyPrefix       = qerrors.New("api key prefix is required and may not contain underscores")
+	ErrInvalidAPIKey             = qerrors.New("api key id and hash are required")
+	ErrAPIKeyExists              = qerrors.New("api key already exists")
+	ErrAPIKeyNotFound            = qerrors.New("api key not found")
+	ErrNoJWKSURL                 = qerrors.New("jwks url is required")
+	ErrNoJWKSKeys                = qerrors.New("jwks contains no usable signing keys")
+	ErrNoOIDCIssuerURL           = qerrors.New("oidc issuer url is required")
+	ErrNoOIDCAudience            = qerrors.New("oidc audience is required")
+	ErrOIDCIssuerMismatch        = qerrors.New("oidc discovery issuer does not match issuer url")
+	ErrNoOIDCSigningMethods      = qerrors.New("oidc provider supports no accepted signing algorithms")
+	ErrNoTransportDestinations   = qerrors.New("at least one transport destination is required")
+	ErrInvalidTransportHost      = qerrors.New("transport destination host is required and may not contain a path")
+	ErrNoServiceIdentity         = qerrors.New("service identity is required")
+	ErrInvalidTokenRefresh       = qerrors.New("service token refresh must be shorter than its lifetime")
+	ErrNoPEMBlock                = qerrors.New("no pem block found")
+	ErrUnsupportedPEMType        = qerrors.New("unsupported pem block type")
+	ErrKeyNotRSA                 = qerrors.New("key is not an rsa key")
+	ErrKeyEnvNotSet              = qerrors.New("key environment variable is not set")
+	ErrNoKeyFilePath             = qerrors.New("key file path is required")
+	ErrNoExchangeSubject         = qerrors.New("token exchange subject is required")
+	ErrNoExchangeActor           = qerrors.New("token exchange actor is required")
+	ErrInvalidExchangeScope      = qerrors.New("token exchange scope not granted to subject")
+	ErrNoSessionManager          = qerrors.New("session manager is required")
+	ErrInvalidSessionLifetime    = qerrors.New("session lifetime may not exceed its max lifetime")
+	ErrNoCORSOrigins             = qerrors.New("cors origins are required")
+	ErrInvalidCORSConfig         = qerrors.New("cors configuration is invalid")
+	ErrNoCORSOriginStore         = qerrors.New("cors origin store is required")
+	ErrNoOTLPEndpoint            = qerrors.New("otlp endpoint is required")
+	ErrOTLPExportFailed          = qerrors.New("otlp export failed")
+	ErrBackgroundPanic           = qerrors.New("background function panicked")
+	ErrInvalidTraceparent        = qerrors.New("traceparent is invalid")
+	ErrInvalidCIDR               = qerrors.New("cidr or ip is invalid")
+	ErrInvalidBaggage            = qerrors.New("baggage is invalid")
+	ErrTenantParamUnsupported    = qerrors.New("tenant param requires echo's router, use PathTenant")
+	ErrInvalidRoutePattern       = qerrors.New("route pattern must be a method and a path, ie: \"GET /docs/*\"")
 	ErrAuthorizationTokenInvalid = qerrors.Unauthorized.NewWithKeyAndDetail(
 		"ERR_AUTHORIZATION_TOKEN_INVALID",
 		"Authorization token is invalid",
@@ -51,9 +110,78 @@ var (
 		"ERR_AUTHORIZATION_BEARER_REQUIRED",
 		"Authorization Bearer is required before token",
 	)
+	ErrAuthorizationInsufficientScope = qerrors.Forbidden.NewWithKeyAndDetail(
+		"ERR_AUTHORIZATION_INSUFFICIENT_SCOPE",
+		"Authorization token does not grant the required scope",
+	)
+	ErrAuthorizationDPoPProofRequired = qerrors.Unauthorized.NewWithKeyAndDetail(
+		"ERR_AUTHORIZATION_DPOP_PROOF_REQUIRED",
+		"A DPoP proof is required for this token",
+	)
+	ErrAuthorizationDPoPProofInvalid = qerrors.Unauthorized.NewWithKeyAndDetail(
+		"ERR_AUTHORIZATION_DPOP_PROOF_INVALID",
+		"DPoP proof is invalid",
+	)
+	ErrSessionRequired = qerrors.Unauthorized.NewWithKeyAndDetail(
+		"ERR_SESSION_REQUIRED",
+		"A session is required",
+	)
+	ErrSessionInvalid = qerrors.Unauthorized.NewWithKeyAndDetail(
+		"ERR_SESSION_INVALID",
+		"Session is invalid or has expired",
+	)
+	ErrCSRFTokenRequired = qerrors.Forbidden.NewWithKeyAndDetail(
+		"ERR_CSRF_TOKEN_REQUIRED",
+		"A CSRF token is required",
+	)
+	ErrCSRFTokenInvalid = qerrors.Forbidden.NewWithKeyAndDetail(
+		"ERR_CSRF_TOKEN_INVALID",
+		"CSRF token is invalid",
+	)
+	ErrImpersonationForbidden = qerrors.Forbidden.NewWithKeyAndDetail(
+		"ERR_IMPERSONATION_FORBIDDEN",
+		"Impersonated requests are not allowed",
+	)
+	ErrTenantRequired = qerrors.Forbidden.NewWithKeyAndDetail(
+		"ERR_TENANT_REQUIRED",
+		"A tenant is required",
+	)
+	ErrTenantMismatch = qerrors.Forbidden.NewWithKeyAndDetail(
+		"ERR_TENANT_MISMATCH",
+		"Tenant does not match the authorization token",
+	)
+	ErrIPNotAllowed = qerrors.Forbidden.NewWithKeyAndDetail(
+		"ERR_IP_NOT_ALLOWED",
+		"Requests are not allowed from this IP",
+	)
+	ErrIPDenied = qerrors.Forbidden.NewWithKeyAndDetail(
+		"ERR_IP_DENIED",
+		"Requests from this IP are denied",
+	)
+	ErrInvalidCSPReport = qerrors.BadRequest.NewWithKeyAndDetail(
+		"ERR_INVALID_CSP_REPORT",
+		"Content security policy report is invalid",
+	)
+	ErrIntrospectionFailed = Unavailable.NewWithKeyAndDetail(
+		"ERR_INTROSPECTION_UNAVAILABLE",
+		"Token introspection is unavailable",
+	)
+	ErrAPIKeyRequired = qerrors.Unauthorized.NewWithKeyAndDetail(
+		"ERR_API_KEY_REQUIRED",
+		"An API key is required",
+	)
+	ErrAPIKeyInvalid = qerrors.Unauthorized.NewWithKeyAndDetail(
+		"ERR_API_KEY_INVALID",
+		"API key is invalid",
+	)
+	ErrAPIKeyExpired = qerrors.Unauthorized.NewWithKeyAndDetail(
+		"ERR_API_KEY_EXPIRED",
+		"API key has expired",
+	)
 )
 
-// Logger returns a middleware that logs HTTP requests.
+// Logger returns a middleware that logs HTTP requests. The logged ip is ClientIP, so configure
+// TrustedProxies as the echo instance's IPExtractor when behind a proxy.
 func Logger() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -83,6 +211,11 @@ func Logger() echo.MiddlewareFunc {
 				rid = ""
 			}
 
+			tc, _ := TraceContextFromContext(c.Request().Context())
+			decisions, _ := correlationDecisionsFromContext(c.Request().Context())
+			tenantID, _ := TenantFromContext(c.Request().Context())
+			subject, actor := principalsFromContext(c.Request().Context())
+
 			logger := log.Logger()
 			logger.SetFormatter(&logrus.JSONFormatter{
 				TimestampFormat: time.RFC3339,
@@ -92,17 +225,24 @@ func Logger() echo.MiddlewareFunc {
 			})
 
 			l := logger.WithFields(logrus.Fields{
-				"provenanceId": pid,
-				"requestId":    rid,
-				"ip":           c.RealIP(),
-				"host":         req.Host,
-				"method":       req.Method,
-				"uri":          req.RequestURI,
-				"status":       res.Status,
-				"latency":      stop.Sub(start).Seconds(),
-				"referer":      req.Referer(),
-				"userAgent":    req.UserAgent(),
-			})
+				"provenanceId":         pid,
+				"provenanceIdDecision": decisions.ProvenanceID,
+				"requestId":            rid,
+				"requestIdDecision":    decisions.RequestID,
+				"traceId":              tc.TraceID,
+				"spanId":               tc.SpanID,
+				"tenantId":             tenantID,
+				"subject":              subject,
+				"actor":                actor,
+				"ip":                   ClientIP(c),
+				"host":                 req.Host,
+				"method":               req.Method,
+				"uri":                  req.RequestURI,
+				"status":               res.Status,
+				"latency":              stop.Sub(start).Seconds(),
+				"referer":              req.Referer(),
+				"userAgent":            req.UserAgent(),
+			}).WithFields(baggageLogFields(c.Request().Context()))
 
 			if res.Status == http.StatusOK || res.Status == http.StatusNoContent {
 				l.Logger.Out = os.Stdout
@@ -256,6 +396,8 @@ func convertError(err error) Error {
 		title = http.StatusText(http.StatusNotFound)
 	case qerrors.MissingParameter:
 		title = "Missing Parameter"
+	case Unavailable:
+		title = http.StatusText(http.StatusServiceUnavailable)
 	case qerrors.NoType:
 		// Errors of unknown/default type should not be exposed
 		return newUnexpectedError(err)
@@ -296,6 +438,8 @@ func ConvertErrorToStatusCode(err error) int {
 		return http.StatusUnprocessableEntity
 	case qerrors.NotFound:
 		return http.StatusNotFound
+	case Unavailable:
+		return http.StatusServiceUnavailable
 	default:
 		return http.StatusInternalServerError
 	}
@@ -312,6 +456,8 @@ func ConvertErrorToGRPCCode(err error) codes.Code {
 		return codes.PermissionDenied
 	case qerrors.NotFound:
 		return codes.NotFound
+	case Unavailable:
+		return codes.Unavailable
 	default:
 		return codes.Unknown
 	}
@@ -329,6 +475,8 @@ func ConvertGRPCCodeToStatusCode(err codes.Code) int {
 		return http.StatusForbidden
 	case codes.NotFound:
 		return http.StatusNotFound
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
 	default:
 		return http.StatusInternalServerError
 	}
@@ -336,53 +484,107 @@ func ConvertGRPCCodeToStatusCode(err codes.Code) int {
 
 // LogAndRenderErrors logs and renders errs to JSON with the provided statusCode.
 func LogAndRenderErrors(c echo.Context, statusCode int, errs ...error) error {
-	errResp := RenderErrors(errs...)
+	return logAndRenderErrors(c.Request().Context(), c.JSON, statusCode, errs)
+}
 
-	// Log error stack trace
-	pid, ok := ProvenanceIDFromContext(c.Request().Context())
-	if !ok {
-		pid = ""
-	}
+// LogAndRenderHTTPErrors is LogAndRenderErrors for net/http handlers.
+func LogAndRenderHTTPErrors(w http.ResponseWriter, r *http.Request, statusCode int, errs ...error) error {
+	return logAndRenderErrors(r.Context(), httpRenderer(w), statusCode, errs)
+}
+
+// LogAndRenderUnexpectedError logs the stack trace for err and renders a generic internal server
+// error message via `RenderUnexpectedAPIError()`.
+func LogAndRenderUnexpectedError(c echo.Context, err error) error {
+	return logAndRenderUnexpectedError(c.Request().Context(), c.JSON, err)
+}
+
+// LogAndRenderHTTPUnexpectedError is LogAndRenderUnexpectedError for net/http handlers.
+func LogAndRenderHTTPUnexpectedError(w http.ResponseWriter, r *http.Request, err error) error {
+	return logAndRenderUnexpectedError(r.Context(), httpRenderer(w), err)
+}
 
-	rid, ok := RequestIDFromContext(c.Request().Context())
-	if !ok {
-		rid = ""
+// renderer writes a JSON response, ie: echo.Context.JSON
+type renderer func(statusCode int, data interface{}) error
+
+// httpRenderer returns a renderer writing to w
+func httpRenderer(w http.ResponseWriter) renderer {
+	return func(statusCode int, data interface{}) error {
+		return JSON(w, statusCode, data)
 	}
+}
+
+func logAndRenderErrors(ctx context.Context, render renderer, statusCode int, errs []error) error {
+	errResp := RenderErrors(errs...)
+
+	// Log error stack trace
+	fields := logFields(ctx)
+	span := SpanFromContext(ctx)
 
 	for _, err := range errs {
-		logger.WithFields(logrus.Fields{"provenanceId": pid, "requestId": rid}).
-			Error(err)
+		logger.WithFields(fields).Error(err)
+		span.RecordError(err)
 	}
 
-	jsonErr := c.JSON(statusCode, errResp)
+	jsonErr := render(statusCode, errResp)
 	if jsonErr != nil {
-		logger.WithFields(logrus.Fields{"provenanceId": pid, "requestId": rid}).Error(jsonErr)
+		logger.WithFields(fields).Error(jsonErr)
 	}
 
 	return errResp
 }
 
-// LogAndRenderUnexpectedError logs the stack trace for err and renders a generic internal server
-// error message via `RenderUnexpectedAPIError()`.
-func LogAndRenderUnexpectedError(c echo.Context, err error) error {
+func logAndRenderUnexpectedError(ctx context.Context, render renderer, err error) error {
 	// Log error stack trace
-	pid, ok := ProvenanceIDFromContext(c.Request().Context())
-	if !ok {
-		pid = ""
-	}
+	fields := logFields(ctx)
 
-	rid, ok := RequestIDFromContext(c.Request().Context())
-	if !ok {
-		rid = ""
-	}
-
-	logger.WithFields(logrus.Fields{"provenanceId": pid, "requestId": rid}).Error(err)
+	logger.WithFields(fields).Error(err)
+	SpanFromContext(ctx).RecordError(err)
 
-	jsonErr := c.JSON(http.StatusInternalServerError, RenderUnexpectedError(err))
+	jsonErr := render(http.StatusInternalServerError, RenderUnexpectedError(err))
 	if jsonErr != nil {
-		logger.WithFields(logrus.Fields{"provenanceId": pid, "requestId": rid}).Error(jsonErr)
+		logger.WithFields(fields).Error(jsonErr)
 	}
 
 	// return the original error which will be logged with Echo's access log
 	return err
 }
+
+// logFields returns the request identifying fields error logs are annotated with
+func logFields(ctx context.Context) logrus.Fields {
+	pid, _ := ProvenanceIDFromContext(ctx)
+	rid, _ := RequestIDFromContext(ctx)
+	tenantID, _ := TenantFromContext(ctx)
+	tc, _ := TraceContextFromContext(ctx)
+
+	fields := baggageLogFields(ctx)
+	fields["provenanceId"] = pid
+	fields["requestId"] = rid
+	fields["traceId"] = tc.TraceID
+	fields["spanId"] = tc.SpanID
+	fields["tenantId"] = tenantID
+
+	return fields
+}
+
+// principalsFromContext returns the subject of the claims in ctx and, for impersonated requests,
+// the actor, falling back to user ids for tokens without a sub claim
+func principalsFromContext(ctx context.Context) (subject, actor string) {
+	claims, err := GetJWTClaimsFromContext(ctx)
+	if err != nil {
+		return "", ""
+	}
+
+	subject = claims.Subject
+	if subject == "" && claims.UserID != 0 {
+		subject = strconv.FormatUint(uint64(claims.UserID), 10)
+	}
+
+	if claims.Actor != nil {
+		actor = claims.Actor.Subject
+		if actor == "" && claims.Actor.UserID != 0 {
+			actor = strconv.FormatUint(uint64(claims.Actor.UserID), 10)
+		}
+	}
+
+	return subject, actor
+}
@@ -165,6 +165,10 @@ func TestConvertGRPCCodeToStatusCode(t *testing.T) {
 			codes.NotFound,
 			http.StatusNotFound,
 		},
+		{
+			codes.Unavailable,
+			http.StatusServiceUnavailable,
+		},
 		{
 			codes.Unknown,
 			http.StatusInternalServerError,
@@ -297,6 +301,11 @@ func TestRenderErrors_Single(t *testing.T) {
 			errors.NotFound.NewWithDetail("Detail here"),
 			`{"errors":[{"title":"Not Found","detail":"Detail here"}]}`,
 		},
+		{
+			"unavailable",
+			Unavailable.NewWithDetail("Detail here"),
+			`{"errors":[{"title":"Service Unavailable","detail":"Detail here"}]}`,
+		},
 		{
 			"noType",
 			errors.NoType.NewWithDetail("Detail here"),
@@ -443,6 +452,11 @@ func TestConvertErrorToStatusCode(t *testing.T) {
 			errors.NotFound.NewWithDetail("error detail"),
 			404,
 		},
+		{
+			"unavailable",
+			Unavailable.NewWithDetail("error detail"),
+			503,
+		},
 		{
 			"noType",
 			fmt.Errorf("standard error"),
@@ -499,6 +513,11 @@ func TestConvertErrorToGRPCCode(t *testing.T) {
 			errors.NotFound.NewWithDetail("error detail"),
 			codes.NotFound,
 		},
+		{
+			"unavailable",
+			Unavailable.NewWithDetail("error detail"),
+			codes.Unavailable,
+		},
 		{
 			"noType",
 			fmt.Errorf("standard error"),
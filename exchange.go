@@ -0,0 +1,123 @@
+package webutils
+
+import (
+	"crypto/rsa"
+	"strings"
+	"time"
+
+	"github.com/cyberhorsey/errors"
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+const defaultExchangedTokenLifetime = 15 * time.Minute
+
+// Actor is the RFC 8693 act claim, identifying who is acting on behalf of a token's subject. In
+// delegation chains the actor's own actor is nested within it.
+type Actor struct {
+	Subject  string `json:"sub"`
+	UserID   uint   `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Actor    *Actor `json:"act,omitempty"`
+}
+
+// Impersonated indicates whether the token was issued to an actor on behalf of its subject
+func (c *Claims) Impersonated() bool {
+	return c.Actor != nil
+}
+
+// TokenExchangerOpts contains the options for NewTokenExchanger
+type TokenExchangerOpts struct {
+	// PrivateKey signs the exchanged tokens, as with CreateJWT
+	PrivateKey *rsa.PrivateKey
+	Issuer     string
+	Audience   string
+	// Lifetime of exchanged tokens, which never outlive the actor's token. Defaults to 15
+	// minutes.
+	Lifetime time.Duration
+}
+
+// TokenExchanger issues RFC 8693 style exchanged tokens, letting an actor, such as a support
+// agent or an upstream service, act as a subject
+type TokenExchanger struct {
+	opts TokenExchangerOpts
+	now  func() time.Time
+}
+
+// NewTokenExchanger creates a TokenExchanger
+func NewTokenExchanger(opts TokenExchangerOpts) (*TokenExchanger, error) {
+	if opts.PrivateKey == nil {
+		return nil, ErrNoKey
+	}
+
+	if opts.Lifetime <= 0 {
+		opts.Lifetime = defaultExchangedTokenLifetime
+	}
+
+	return &TokenExchanger{
+		opts: opts,
+		now:  time.Now,
+	}, nil
+}
+
+// Exchange issues an access token for subject with an act claim identifying actor. Any act claim
+// of the actor is nested, preserving delegation chains. The token is granted scope, which must
+// be a subset of the subject's scopes, or all of the subject's scopes when empty.
+func (x *TokenExchanger) Exchange(subject, actor *Claims, scope string) (string, error) {
+	if subject == nil {
+		return "", ErrNoExchangeSubject
+	}
+
+	if actor == nil {
+		return "", ErrNoExchangeActor
+	}
+
+	if scope == "" {
+		scope = subject.Scope
+	}
+
+	for _, s := range strings.Fields(scope) {
+		if !subject.HasScope(s) {
+			return "", errors.Wrapf(ErrInvalidExchangeScope, "%q", s)
+		}
+	}
+
+	jti, err := SecureRandomHex(16)
+	if err != nil {
+		return "", errors.Wrap(err, "SecureRandomHex")
+	}
+
+	now := x.now()
+	expiresAt := now.Add(x.opts.Lifetime).Unix()
+
+	if actor.ExpiresAt != 0 && actor.ExpiresAt < expiresAt {
+		expiresAt = actor.ExpiresAt
+	}
+
+	token, err := CreateJWT(Claims{
+		Type:     string(JWTAccess),
+		UserID:   subject.UserID,
+		Username: subject.Username,
+		Scope:    scope,
+		TenantID: subject.TenantID,
+		Actor: &Actor{
+			Subject:  actor.Subject,
+			UserID:   actor.UserID,
+			Username: actor.Username,
+			Actor:    actor.Actor,
+		},
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   subject.Subject,
+			Issuer:    x.opts.Issuer,
+			Audience:  x.opts.Audience,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: expiresAt,
+		},
+	}, x.opts.PrivateKey)
+	if err != nil {
+		return "", errors.Wrap(err, "CreateJWT")
+	}
+
+	return token, nil
+}
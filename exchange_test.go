@@ -0,0 +1,85 @@
+package webutils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewTokenExchanger(t *testing.T) {
+	_, err := NewTokenExchanger(TokenExchangerOpts{})
+	assert.Equal(t, ErrNoKey, err)
+}
+
+func Test_TokenExchanger_Exchange(t *testing.T) {
+	x, err := NewTokenExchanger(TokenExchangerOpts{PrivateKey: testPrivateKey, Issuer: "cyberhorsey"})
+	assert.Nil(t, err)
+
+	customer := &Claims{
+		UserID:         42,
+		Username:       "customer",
+		TenantID:       "7",
+		Scope:          "orders:read orders:write",
+		StandardClaims: jwt.StandardClaims{Subject: "42"},
+	}
+
+	agent := &Claims{
+		UserID:         1,
+		Username:       "support",
+		StandardClaims: jwt.StandardClaims{Subject: "1", ExpiresAt: time.Now().Add(5 * time.Minute).Unix()},
+	}
+
+	_, err = x.Exchange(nil, agent, "")
+	assert.Equal(t, ErrNoExchangeSubject, err)
+
+	_, err = x.Exchange(customer, nil, "")
+	assert.Equal(t, ErrNoExchangeActor, err)
+
+	_, err = x.Exchange(customer, agent, "orders:read admin")
+	assert.True(t, errors.Is(err, ErrInvalidExchangeScope))
+
+	token, err := x.Exchange(customer, agent, "orders:read")
+	assert.Nil(t, err)
+
+	claims, err := GetClaimsFromJWT(token, &testPrivateKey.PublicKey)
+	assert.Nil(t, err)
+	assert.True(t, claims.Impersonated())
+	assert.Equal(t, "42", claims.Subject)
+	assert.Equal(t, uint(42), claims.UserID)
+	assert.Equal(t, "7", claims.TenantID)
+	assert.Equal(t, "orders:read", claims.Scope)
+	assert.Equal(t, "cyberhorsey", claims.Issuer)
+	assert.Equal(t, &Actor{Subject: "1", UserID: 1, Username: "support"}, claims.Actor)
+
+	// never outlives the actor's token
+	assert.Equal(t, agent.ExpiresAt, claims.ExpiresAt)
+
+	// a service acting on the exchanged token's behalf nests the chain
+	service := &Claims{Username: "billing", StandardClaims: jwt.StandardClaims{Subject: "billing"}}
+	service.Actor = claims.Actor
+
+	chained, err := x.Exchange(customer, service, "")
+	assert.Nil(t, err)
+
+	chainedClaims, err := GetClaimsFromJWT(chained, &testPrivateKey.PublicKey)
+	assert.Nil(t, err)
+	assert.Equal(t, customer.Scope, chainedClaims.Scope)
+	assert.Equal(t, "billing", chainedClaims.Actor.Subject)
+	assert.Equal(t, "1", chainedClaims.Actor.Actor.Subject)
+}
+
+func Test_principalsFromContext(t *testing.T) {
+	subject, actor := principalsFromContext(context.Background())
+	assert.Equal(t, "", subject)
+	assert.Equal(t, "", actor)
+
+	claims := &Claims{UserID: 42, Actor: &Actor{Subject: "support-1"}}
+
+	subject, actor = principalsFromContext(newJWTContext(context.Background(), claims, "token"))
+	assert.Equal(t, "42", subject)
+	assert.Equal(t, "support-1", actor)
+}
@@ -0,0 +1,158 @@
+package webutils
+
+import (
+	"context"
+	"crypto/rsa"
+
+	"github.com/cyberhorsey/errors"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcAuthorizationMetadataKey is the metadata key holding the Bearer token. gRPC metadata keys
+// are always lowercase.
+const grpcAuthorizationMetadataKey = "authorization"
+
+// GRPCAuthOpts contains the options for ConfigureGRPCAuthInterceptors. The token options match
+// those of JWTMiddlewareOpts.
+type GRPCAuthOpts struct {
+	PublicKey          func(ctx context.Context) (*rsa.PublicKey, error)
+	KeyFunc            jwt.Keyfunc
+	Issuer             string
+	Audience           string
+	SigningMethods     []string
+	AllowUntypedTokens bool
+	// SkipMethods are full method names which are not authenticated, ie:
+	// "/grpc.health.v1.Health/Check"
+	SkipMethods []string
+}
+
+// grpcAuthInterceptor authenticates gRPC calls with the JWT validation of the echo middleware
+type grpcAuthInterceptor struct {
+	publicKey          func(ctx context.Context) (*rsa.PublicKey, error)
+	keyFunc            jwt.Keyfunc
+	issuer             string
+	audience           string
+	signingMethods     []string
+	allowUntypedTokens bool
+	skipMethods        map[string]bool
+}
+
+// ConfigureGRPCAuthInterceptors configures unary and stream server interceptors which
+// authenticate calls by the Bearer token in their authorization metadata. The claims and token
+// are stored in the context as they are by the JWT middleware, so GetJWTClaimsFromContext and
+// GetJWTFromContext work the same in gRPC handlers.
+func ConfigureGRPCAuthInterceptors(
+	opts GRPCAuthOpts,
+) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor, error) {
+	if opts.PublicKey == nil && opts.KeyFunc == nil {
+		return nil, nil, ErrNoPublicKeyFunction
+	}
+
+	i := &grpcAuthInterceptor{
+		publicKey:          opts.PublicKey,
+		keyFunc:            opts.KeyFunc,
+		issuer:             opts.Issuer,
+		audience:           opts.Audience,
+		signingMethods:     opts.SigningMethods,
+		allowUntypedTokens: opts.AllowUntypedTokens,
+		skipMethods:        make(map[string]bool),
+	}
+
+	for _, m := range opts.SkipMethods {
+		i.skipMethods[m] = true
+	}
+
+	return i.Unary, i.Stream, nil
+}
+
+// Unary is a grpc.UnaryServerInterceptor
+func (i *grpcAuthInterceptor) Unary(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if i.skipMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+
+	ctx, err := i.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// Stream is a grpc.StreamServerInterceptor
+func (i *grpcAuthInterceptor) Stream(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if i.skipMethods[info.FullMethod] {
+		return handler(srv, ss)
+	}
+
+	ctx, err := i.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticate validates the call's Bearer token, returning a context carrying its claims
+func (i *grpcAuthInterceptor) authenticate(ctx context.Context) (context.Context, error) {
+	keyFunc := i.keyFunc
+	if keyFunc == nil {
+		pk, err := i.publicKey(ctx)
+		if err != nil {
+			if errors.GetType(err) != errors.NoType {
+				return nil, status.Error(ConvertErrorToGRPCCode(err), errors.Detail(err))
+			}
+
+			// only the generic message is returned to the caller, so log the cause
+			logger.WithFields(logFields(ctx)).Error(errors.Wrap(err, "i.publicKey"))
+
+			return nil, status.Error(codes.Internal, "An unexpected error occurred.")
+		}
+
+		keyFunc = publicKeyFunc(pk)
+	}
+
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(grpcAuthorizationMetadataKey); len(values) > 0 {
+			header = values[0]
+		}
+	}
+
+	claims, token, err := authenticateBearerJWT(header, JWTParseOpts{
+		KeyFunc:        keyFunc,
+		Issuer:         i.issuer,
+		Audience:       i.audience,
+		SigningMethods: i.signingMethods,
+	}, i.allowUntypedTokens)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, errors.Detail(err))
+	}
+
+	return newJWTContext(ctx, claims, token), nil
+}
+
+// contextServerStream is a grpc.ServerStream with an overridden context
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the overridden context
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
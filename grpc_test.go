@@ -0,0 +1,123 @@
+package webutils
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// testServerStream is a grpc.ServerStream stand-in carrying only a context
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func testGRPCPublicKeyFunc(ctx context.Context) (*rsa.PublicKey, error) {
+	return &testPrivateKey.PublicKey, nil
+}
+
+func Test_ConfigureGRPCAuthInterceptors(t *testing.T) {
+	_, _, err := ConfigureGRPCAuthInterceptors(GRPCAuthOpts{})
+	assert.Equal(t, ErrNoPublicKeyFunction, err)
+
+	unary, stream, err := ConfigureGRPCAuthInterceptors(GRPCAuthOpts{
+		PublicKey:   testGRPCPublicKeyFunc,
+		SkipMethods: []string{"/grpc.health.v1.Health/Check"},
+	})
+	assert.Nil(t, err)
+
+	valid := newTestJWT(t, newTestClaims(JWTAccess, time.Hour))
+	refresh := newTestJWT(t, newTestClaims(JWTRefresh, time.Hour))
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		wantCode      codes.Code
+		wantClaims    bool
+	}{
+		{"valid", "/svc.Users/Get", "Bearer " + valid, codes.OK, true},
+		{"missing", "/svc.Users/Get", "", codes.Unauthenticated, false},
+		{"no bearer", "/svc.Users/Get", valid, codes.Unauthenticated, false},
+		{"malformed", "/svc.Users/Get", "Bearer malformed", codes.Unauthenticated, false},
+		{"refresh token", "/svc.Users/Get", "Bearer " + refresh, codes.Unauthenticated, false},
+		{"skipped", "/grpc.health.v1.Health/Check", "", codes.OK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.authorization))
+			}
+
+			assertCtx := func(ctx context.Context) {
+				claims, err := GetJWTClaimsFromContext(ctx)
+				token, _ := GetJWTFromContext(ctx)
+
+				if tt.wantClaims {
+					assert.Nil(t, err)
+					assert.Equal(t, uint(1), claims.UserID)
+					assert.Equal(t, valid, token)
+				} else {
+					assert.Equal(t, ErrNoJWTClaimsInContext, err)
+				}
+			}
+
+			_, err := unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					assertCtx(ctx)
+					return nil, nil
+				},
+			)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+
+			err = stream(nil, &testServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: tt.method},
+				func(srv interface{}, ss grpc.ServerStream) error {
+					assertCtx(ss.Context())
+					return nil
+				},
+			)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+		})
+	}
+}
+
+func Test_ConfigureGRPCAuthInterceptors_PublicKeyError(t *testing.T) {
+	unary, _, err := ConfigureGRPCAuthInterceptors(GRPCAuthOpts{
+		PublicKey: func(ctx context.Context) (*rsa.PublicKey, error) {
+			return nil, ErrNoKey
+		},
+	})
+	assert.Nil(t, err)
+
+	out := logger.Out
+	buf := &bytes.Buffer{}
+	logger.SetOutput(buf)
+
+	defer logger.SetOutput(out)
+
+	ctx := NewContext(context.Background(), "pid", "rid")
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer token"))
+
+	_, err = unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/svc.Users/Get"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		},
+	)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Contains(t, buf.String(), "i.publicKey")
+	assert.Contains(t, buf.String(), `"provenanceId":"pid"`)
+}
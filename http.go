@@ -0,0 +1,152 @@
+package webutils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/cyberhorsey/errors"
+	echo "github.com/labstack/echo/v4"
+)
+
+const echoContextKey ctxKey = ctxKey(4208)
+
+// HTTPAdapter runs echo middleware as net/http middleware, for services using the standard
+// library mux, so both share one implementation.
+//
+// Without echo's router, c.Path() is the cleaned request path, so RouteMatcher patterns only match
+// with literal and wildcard segments; route params never match, failing closed. Values middleware
+// set on the echo.Context, ie: the auth realm RequireScopes checks, carry over between adapted
+// middleware in the same chain.
+type HTTPAdapter struct {
+	echo *echo.Echo
+}
+
+// NewHTTPAdapter creates an HTTPAdapter running middleware with e, whose IPExtractor is used by
+// ClientIP and whose HTTPErrorHandler renders errors middleware return without a response.
+// Defaults to echo.New().
+func NewHTTPAdapter(e *echo.Echo) *HTTPAdapter {
+	if e == nil {
+		e = echo.New()
+	}
+
+	return &HTTPAdapter{echo: e}
+}
+
+var defaultHTTPAdapter = NewHTTPAdapter(nil)
+
+// HTTPMiddleware runs mw as net/http middleware with the default HTTPAdapter, ie:
+// HTTPMiddleware(ConfigureSecurityHeadersMiddleware(opts))
+func HTTPMiddleware(mw echo.MiddlewareFunc) func(http.Handler) http.Handler {
+	return defaultHTTPAdapter.Middleware(mw)
+}
+
+// Middleware runs mw as net/http middleware
+func (a *HTTPAdapter) Middleware(mw echo.MiddlewareFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		h := mw(func(c echo.Context) error {
+			next.ServeHTTP(c.Response(), c.Request())
+			return nil
+		})
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c := a.context(w, r)
+
+			if err := h(c); err != nil && !c.Response().Committed {
+				c.Echo().HTTPErrorHandler(err, c)
+			}
+		})
+	}
+}
+
+// context returns the echo.Context of an adapted middleware further up the chain, or a new one
+func (a *HTTPAdapter) context(w http.ResponseWriter, r *http.Request) echo.Context {
+	c, ok := echoContextFromRequest(r)
+	if !ok {
+		c = a.echo.NewContext(r, w)
+		c.SetPath(adaptedRoutePath(r.URL.Path))
+		c.SetRequest(r.WithContext(context.WithValue(r.Context(), echoContextKey, c)))
+
+		return c
+	}
+
+	c.SetRequest(r)
+
+	// net/http middleware in between may have wrapped the response
+	if res, ok := w.(*echo.Response); !ok || res != c.Response() {
+		c.SetResponse(echo.NewResponse(w, c.Echo()))
+	}
+
+	return c
+}
+
+// adaptedRoutePath returns the path RouteMatcher matches requests of adapted middleware against:
+// urlPath cleaned, as routers may not, so "/docs/../admin" can't match "/docs/*", and with the
+// segments of the URL which look like route params or wildcards escaped, as they're literals.
+func adaptedRoutePath(urlPath string) string {
+	segments := strings.Split(path.Clean("/"+urlPath), "/")
+
+	for i, s := range segments {
+		if strings.HasPrefix(s, routeParam) || s == routeWildcard {
+			segments[i] = fmt.Sprintf("%%%02X", s[0]) + s[1:]
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// echoContextFromRequest returns the echo.Context adapted middleware ran with
+func echoContextFromRequest(r *http.Request) (echo.Context, bool) {
+	c, ok := r.Context().Value(echoContextKey).(echo.Context)
+	return c, ok
+}
+
+// HTTPProvenanceIDMiddleware is ProvenanceIDMiddleware for net/http
+func HTTPProvenanceIDMiddleware(next http.Handler) http.Handler {
+	return HTTPMiddleware(ProvenanceIDMiddleware)(next)
+}
+
+// HTTPLogger is Logger for net/http
+func HTTPLogger() func(http.Handler) http.Handler {
+	return HTTPMiddleware(Logger())
+}
+
+// ConfigureHTTPJWTMiddleware is ConfigureJWTMiddleware for net/http. The claims and token are
+// read with GetJWTClaimsFromContext and GetJWTFromContext. PublicRoutes with route params are
+// rejected with ErrInvalidRoutePattern, as there are no route templates to match them against.
+func ConfigureHTTPJWTMiddleware(opts JWTMiddlewareOpts) (func(http.Handler) http.Handler, error) {
+	for _, pattern := range opts.PublicRoutes {
+		compiled, err := compileRoutePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		if compiled.hasParams() {
+			return nil, errors.Wrapf(ErrInvalidRoutePattern, "%q has route params", pattern)
+		}
+	}
+
+	mw, err := ConfigureJWTMiddleware(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return HTTPMiddleware(mw), nil
+}
+
+// ConfigureHTTPTenantMiddleware is ConfigureTenantMiddleware for net/http. A Param is rejected
+// with ErrTenantParamUnsupported, as there are no route params to read it from; use PathTenant.
+func ConfigureHTTPTenantMiddleware(opts TenantMiddlewareOpts) (func(http.Handler) http.Handler, error) {
+	if opts.Param != "" {
+		return nil, ErrTenantParamUnsupported
+	}
+
+	return HTTPMiddleware(ConfigureTenantMiddleware(opts)), nil
+}
+
+// ConfigureHTTPCORSMiddleware is ConfigureCORSMiddleware for net/http
+func ConfigureHTTPCORSMiddleware(corsDomains []string) func(http.Handler) http.Handler {
+	return HTTPMiddleware(ConfigureCORSMiddleware(corsDomains))
+}
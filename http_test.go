@@ -0,0 +1,266 @@
+package webutils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LogAndRenderHTTPErrors(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	rec := httptest.NewRecorder()
+	_ = LogAndRenderHTTPErrors(rec, req, http.StatusForbidden, ErrIPDenied)
+
+	echoRec := httptest.NewRecorder()
+	_ = LogAndRenderErrors(echo.New().NewContext(req, echoRec), http.StatusForbidden, ErrIPDenied)
+
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.JSONEq(t, echoRec.Body.String(), rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	err := LogAndRenderHTTPUnexpectedError(rec, req, errors.New("boom"))
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), "ERR_UNEXPECTED")
+}
+
+func Test_HTTPMiddleware(t *testing.T) {
+	var (
+		pid, rid string
+		nonce    string
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		pid, _ = ProvenanceIDFromContext(r.Context())
+		rid, _ = RequestIDFromContext(r.Context())
+		nonce = CSPNonceFromRequest(r)
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	h := HTTPProvenanceIDMiddleware(HTTPLogger()(
+		HTTPMiddleware(ConfigureSecurityHeadersMiddleware(SecurityHeadersOpts{Profile: SecurityHeadersHTML}))(mux),
+	))
+
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set(ProvenanceIDHeader, "pid")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "pid", pid)
+	assert.NotEmpty(t, rid)
+	assert.Equal(t, rid, rec.Header().Get(RequestIDHeader))
+	assert.NotEmpty(t, nonce)
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentSecurityPolicy), nonce)
+}
+
+func Test_HTTPMiddleware_Errors(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	h := HTTPMiddleware(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			return echo.NewHTTPError(http.StatusTeapot)
+		}
+	})(next)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+
+	// errors already rendered aren't rendered again
+	h = HTTPMiddleware(ConfigureCSRFMiddleware(CSRFMiddlewareOpts{}))(next)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "ERR_CSRF_TOKEN_REQUIRED")
+}
+
+func Test_ConfigureHTTPJWTMiddleware(t *testing.T) {
+	_, err := ConfigureHTTPJWTMiddleware(JWTMiddlewareOpts{})
+	assert.Equal(t, ErrNoPublicKeyFunction, err)
+
+	jwtMiddleware, err := ConfigureHTTPJWTMiddleware(JWTMiddlewareOpts{
+		PublicKey: testPublicKeyFunc,
+		Realm:     "users",
+	})
+	assert.Nil(t, err)
+
+	var claims *Claims
+
+	h := jwtMiddleware(HTTPMiddleware(RequireScopes("admin"))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ = GetJWTClaimsFromContext(r.Context())
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	))
+
+	admin := newTestClaims(JWTAccess, time.Hour)
+	admin.Scope = "admin"
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantClaims    bool
+	}{
+		{"valid", "Bearer " + newTestJWT(t, admin), http.StatusNoContent, true},
+		{"missing", "", http.StatusUnauthorized, false},
+		{"insufficient scope", "Bearer " + newTestJWT(t, newTestClaims(JWTAccess, time.Hour)), http.StatusForbidden, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims = nil
+
+			req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+			if tt.authorization != "" {
+				req.Header.Set(echo.HeaderAuthorization, tt.authorization)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantClaims, claims != nil)
+
+			if tt.wantStatus != http.StatusNoContent {
+				// the realm set by the JWT middleware carries over to RequireScopes
+				assert.True(t, strings.Contains(rec.Header().Get(echo.HeaderWWWAuthenticate), `realm="users"`))
+			}
+		})
+	}
+}
+
+func Test_ConfigureHTTPTenantMiddleware(t *testing.T) {
+	_, err := ConfigureHTTPTenantMiddleware(TenantMiddlewareOpts{Param: "tenantId"})
+	assert.Equal(t, ErrTenantParamUnsupported, err)
+
+	jwtMiddleware, err := ConfigureHTTPJWTMiddleware(JWTMiddlewareOpts{PublicKey: testPublicKeyFunc})
+	assert.Nil(t, err)
+
+	tenantMiddleware, err := ConfigureHTTPTenantMiddleware(TenantMiddlewareOpts{
+		PathTenant: func(c echo.Context) string {
+			// "/tenants/{tenantId}/..."
+			if parts := strings.Split(c.Request().URL.Path, "/"); len(parts) > 2 && parts[1] == "tenants" {
+				return parts[2]
+			}
+
+			return ""
+		},
+	})
+	assert.Nil(t, err)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	claims := newTestClaims(JWTAccess, time.Hour)
+	claims.TenantID = "A"
+	token := "Bearer " + newTestJWT(t, claims)
+
+	tests := []struct {
+		name       string
+		h          http.Handler
+		path       string
+		wantStatus int
+	}{
+		{"own tenant", jwtMiddleware(tenantMiddleware(next)), "/tenants/A/users", http.StatusNoContent},
+		{"other tenant", jwtMiddleware(tenantMiddleware(next)), "/tenants/B/users", http.StatusForbidden},
+		{
+			"adapted param fails closed",
+			jwtMiddleware(HTTPMiddleware(ConfigureTenantMiddleware(TenantMiddlewareOpts{Param: "tenantId"}))(next)),
+			"/tenants/B/users",
+			http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set(echo.HeaderAuthorization, token)
+
+			rec := httptest.NewRecorder()
+			tt.h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
+
+func Test_ConfigureHTTPCORSMiddleware(t *testing.T) {
+	h := ConfigureHTTPCORSMiddleware([]string{"https://app.example.com"})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderOrigin, "https://app.example.com")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+}
+
+func Test_ConfigureHTTPJWTMiddleware_PublicRoutes(t *testing.T) {
+	_, err := ConfigureHTTPJWTMiddleware(JWTMiddlewareOpts{
+		PublicKey:    testPublicKeyFunc,
+		PublicRoutes: []string{"GET /users/:id"},
+	})
+	assert.True(t, errors.Is(err, ErrInvalidRoutePattern))
+
+	jwtMiddleware, err := ConfigureHTTPJWTMiddleware(JWTMiddlewareOpts{
+		PublicKey:    testPublicKeyFunc,
+		PublicRoutes: []string{"GET /docs/*", "GET /status"},
+	})
+	assert.Nil(t, err)
+
+	h := jwtMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{"/docs/openapi.json", http.StatusNoContent},
+		{"/status", http.StatusNoContent},
+		{"/status/", http.StatusNoContent},
+		{"/docs/../admin", http.StatusUnauthorized},
+		{"/docs/%2e%2e/admin", http.StatusUnauthorized},
+		{"//admin", http.StatusUnauthorized},
+		{"/:status", http.StatusUnauthorized},
+		{"/*", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
+
+func Test_adaptedRoutePath(t *testing.T) {
+	assert.Equal(t, "/", adaptedRoutePath(""))
+	assert.Equal(t, "/admin", adaptedRoutePath("/docs/../admin"))
+	assert.Equal(t, "/users/1", adaptedRoutePath("//users/1/"))
+	assert.Equal(t, "/users/%3Aid/%2A", adaptedRoutePath("/users/:id/*"))
+}
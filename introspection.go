@@ -0,0 +1,352 @@
+package webutils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyberhorsey/errors"
+	jwt "github.com/golang-jwt/jwt/v4"
+	echo "github.com/labstack/echo/v4"
+)
+
+const (
+	defaultIntrospectionCacheTTL = time.Minute
+	maxIntrospectionCacheEntries = 10000
+)
+
+// IntrospectorOpts contains the options for NewIntrospector
+type IntrospectorOpts struct {
+	// Endpoint is the RFC 7662 introspection endpoint of the authorization server
+	Endpoint string
+	// ClientID and ClientSecret authenticate us to the authorization server via HTTP Basic auth
+	ClientID     string
+	ClientSecret string
+	// HTTPClient is used to call Endpoint. Defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+	// CacheTTL is how long inactive tokens, and active tokens without an exp, are cached.
+	// Defaults to one minute.
+	CacheTTL time.Duration
+	// Audience, when set, must be one of the token's audiences; tokens for other audiences are
+	// treated as inactive. The claims' Audience is then the configured audience.
+	Audience string
+}
+
+// Introspector validates opaque access tokens against an RFC 7662 introspection endpoint,
+// caching results until the token expires.
+type Introspector struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+	cacheTTL     time.Duration
+	audience     string
+	now          func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]introspectionResult
+}
+
+// introspectionResult is a cached introspection outcome; claims is nil for inactive tokens
+type introspectionResult struct {
+	claims    *Claims
+	expiresAt time.Time
+}
+
+// introspectionResponse is the RFC 7662 introspection response
+type introspectionResponse struct {
+	Active    bool             `json:"active"`
+	Scope     string           `json:"scope"`
+	ClientID  string           `json:"client_id"`
+	Username  string           `json:"username"`
+	TokenType string           `json:"token_type"`
+	Exp       int64            `json:"exp"`
+	Iat       int64            `json:"iat"`
+	Nbf       int64            `json:"nbf"`
+	Sub       string           `json:"sub"`
+	Aud       jwt.ClaimStrings `json:"aud"`
+	Iss       string           `json:"iss"`
+	Jti       string           `json:"jti"`
+	TenantID  string           `json:"tenant_id"`
+}
+
+// NewIntrospector creates an Introspector
+func NewIntrospector(opts IntrospectorOpts) (*Introspector, error) {
+	if opts.Endpoint == "" {
+		return nil, ErrNoIntrospectionEndpoint
+	}
+
+	if _, err := url.ParseRequestURI(opts.Endpoint); err != nil {
+		return nil, errors.Wrap(err, "url.ParseRequestURI(opts.Endpoint)")
+	}
+
+	if opts.ClientID == "" {
+		return nil, ErrNoIntrospectionClientID
+	}
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = defaultIntrospectionCacheTTL
+	}
+
+	return &Introspector{
+		endpoint:     opts.Endpoint,
+		clientID:     opts.ClientID,
+		clientSecret: opts.ClientSecret,
+		client:       opts.HTTPClient,
+		cacheTTL:     opts.CacheTTL,
+		audience:     opts.Audience,
+		now:          time.Now,
+		cache:        make(map[string]introspectionResult),
+	}, nil
+}
+
+// Introspect returns the Claims for an active token, or ErrAuthorizationTokenInvalid if the
+// authorization server reports the token as inactive.
+func (i *Introspector) Introspect(ctx context.Context, token string) (*Claims, error) {
+	if token == "" {
+		return nil, ErrAuthorizationAccessTokenRequired
+	}
+
+	// only the token hash is kept in memory
+	sum := sha256.Sum256([]byte(token))
+	cacheKey := hex.EncodeToString(sum[:])
+
+	if result, ok := i.cached(cacheKey); ok {
+		return result.claimsOrErr()
+	}
+
+	resp, err := i.introspect(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	result := i.newResult(resp)
+	i.store(cacheKey, result)
+
+	return result.claimsOrErr()
+}
+
+func (i *Introspector) introspect(ctx context.Context, token string) (*introspectionResponse, error) {
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", "access_token")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.Wrap(err, "http.NewRequestWithContext")
+	}
+
+	req.SetBasicAuth(url.QueryEscape(i.clientID), url.QueryEscape(i.clientSecret))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+
+	res, err := i.client.Do(req)
+	if err != nil {
+		return nil, errors.WithCause(ErrIntrospectionFailed, errors.Wrap(err, "i.client.Do"))
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Wrapf(ErrIntrospectionFailed, "status %v", res.StatusCode)
+	}
+
+	resp := &introspectionResponse{}
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return nil, errors.Wrap(err, "json.NewDecoder(res.Body).Decode")
+	}
+
+	return resp, nil
+}
+
+// newResult maps an introspection response into a cacheable result
+func (i *Introspector) newResult(resp *introspectionResponse) introspectionResult {
+	now := i.now()
+	inactive := introspectionResult{expiresAt: now.Add(i.cacheTTL)}
+
+	if !resp.Active {
+		return inactive
+	}
+
+	expiresAt := now.Add(i.cacheTTL)
+
+	if resp.Exp != 0 {
+		expiresAt = time.Unix(resp.Exp, 0)
+		if !now.Before(expiresAt) {
+			return inactive
+		}
+	}
+
+	claims := &Claims{
+		Type:     string(JWTAccess),
+		Username: resp.Username,
+		Scope:    resp.Scope,
+		TenantID: resp.TenantID,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   resp.Sub,
+			Issuer:    resp.Iss,
+			Id:        resp.Jti,
+			ExpiresAt: resp.Exp,
+			IssuedAt:  resp.Iat,
+			NotBefore: resp.Nbf,
+		},
+	}
+
+	// the whole aud is checked before it's reduced to the single audience Claims hold
+	switch {
+	case i.audience != "":
+		if !hasAudience(resp.Aud, i.audience) {
+			return inactive
+		}
+
+		claims.Audience = i.audience
+	case len(resp.Aud) > 0:
+		claims.Audience = resp.Aud[0]
+	}
+
+	if userID, err := strconv.ParseUint(resp.Sub, 10, 0); err == nil {
+		claims.UserID = uint(userID)
+	}
+
+	return introspectionResult{claims: claims, expiresAt: expiresAt}
+}
+
+// hasAudience indicates whether audience is one of aud
+func hasAudience(aud []string, audience string) bool {
+	for _, a := range aud {
+		if a == audience {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (i *Introspector) cached(key string) (introspectionResult, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	result, ok := i.cache[key]
+	if !ok {
+		return introspectionResult{}, false
+	}
+
+	if !i.now().Before(result.expiresAt) {
+		delete(i.cache, key)
+		return introspectionResult{}, false
+	}
+
+	return result, true
+}
+
+func (i *Introspector) store(key string, result introspectionResult) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// evict expired results before the cache grows unbounded
+	if len(i.cache) >= maxIntrospectionCacheEntries {
+		now := i.now()
+
+		for k, v := range i.cache {
+			if !now.Before(v.expiresAt) {
+				delete(i.cache, k)
+			}
+		}
+	}
+
+	if len(i.cache) < maxIntrospectionCacheEntries {
+		i.cache[key] = result
+	}
+}
+
+func (r introspectionResult) claimsOrErr() (*Claims, error) {
+	if r.claims == nil {
+		return nil, ErrAuthorizationTokenInvalid
+	}
+
+	// hand out copies so callers can't mutate cached claims
+	claims := *r.claims
+
+	return &claims, nil
+}
+
+// IntrospectionMiddlewareOpts contains the options for ConfigureIntrospectionMiddleware
+type IntrospectionMiddlewareOpts struct {
+	Introspector *Introspector
+	Skipper      func(c echo.Context) bool
+	// Optional lets requests without an Authorization header through without claims
+	Optional bool
+	// Realm is sent in the WWW-Authenticate challenge of rejected requests
+	Realm string
+}
+
+// introspectionMiddleware authenticates opaque Bearer tokens via an Introspector
+type introspectionMiddleware struct {
+	Introspector *Introspector
+	Skipper      func(c echo.Context) bool
+	Optional     bool
+	Realm        string
+}
+
+// ConfigureIntrospectionMiddleware configures middleware that authenticates opaque Bearer tokens
+// through token introspection, populating the same context values as the JWT middleware.
+func ConfigureIntrospectionMiddleware(opts IntrospectionMiddlewareOpts) (echo.MiddlewareFunc, error) {
+	mw := introspectionMiddleware(opts)
+	if mw.Introspector == nil {
+		return nil, ErrNoIntrospector
+	}
+
+	if mw.Skipper == nil {
+		mw.Skipper = defaultJWTMiddlewareSkipper
+	}
+
+	return mw.Handler, nil
+}
+
+func (mw *introspectionMiddleware) Handler(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if mw.Skipper(c) {
+			return next(c)
+		}
+
+		c.Set(contextKeyAuthRealm, mw.Realm)
+
+		header := c.Request().Header.Get(echo.HeaderAuthorization)
+		if header == "" && mw.Optional {
+			return next(c)
+		}
+
+		token, err := getBearerToken(header)
+		if err != nil {
+			setBearerChallenge(c, mw.Realm, challengeError(header, err))
+			return LogAndRenderErrors(c, http.StatusUnauthorized, err)
+		}
+
+		claims, err := mw.Introspector.Introspect(c.Request().Context(), token)
+		if err != nil {
+			if errors.GetType(err) != errors.NoType {
+				if errors.GetType(err) == errors.Unauthorized {
+					setBearerChallenge(c, mw.Realm, err)
+				}
+
+				return LogAndRenderErrors(c, ConvertErrorToStatusCode(err), err)
+			}
+
+			return LogAndRenderUnexpectedError(c, errors.Wrap(err, "mw.Introspector.Introspect"))
+		}
+
+		setJWTContext(c, claims, token)
+
+		return next(c)
+	}
+}
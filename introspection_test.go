@@ -0,0 +1,274 @@
+package webutils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestIntrospectionServer is an authorization server stand-in which reports the tokens in
+// active as active, and counts the introspection calls it receives.
+func newTestIntrospectionServer(
+	t *testing.T,
+	active map[string]map[string]interface{},
+	calls *int32,
+) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok || clientID != "client" || clientSecret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "access_token", r.FormValue("token_type_hint"))
+
+		resp, ok := active[r.FormValue("token")]
+		if !ok {
+			resp = map[string]interface{}{"active": false}
+		}
+
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func Test_NewIntrospector(t *testing.T) {
+	_, err := NewIntrospector(IntrospectorOpts{ClientID: "client"})
+	assert.Equal(t, ErrNoIntrospectionEndpoint, err)
+
+	_, err = NewIntrospector(IntrospectorOpts{Endpoint: "not a url", ClientID: "client"})
+	assert.NotNil(t, err)
+
+	_, err = NewIntrospector(IntrospectorOpts{Endpoint: "https://auth.example.com/introspect"})
+	assert.Equal(t, ErrNoIntrospectionClientID, err)
+
+	i, err := NewIntrospector(IntrospectorOpts{Endpoint: "https://auth.example.com/introspect", ClientID: "client"})
+	assert.Nil(t, err)
+	assert.Equal(t, defaultIntrospectionCacheTTL, i.cacheTTL)
+}
+
+func Test_Introspector_Introspect(t *testing.T) {
+	var calls int32
+
+	exp := time.Now().Add(time.Hour).Unix()
+
+	srv := newTestIntrospectionServer(t, map[string]map[string]interface{}{
+		"active-token": {
+			"active":   true,
+			"scope":    "read write",
+			"sub":      "42",
+			"username": "tester",
+			"aud":      []string{"api"},
+			"exp":      exp,
+		},
+		"expired-token": {
+			"active": true,
+			"sub":    "42",
+			"exp":    time.Now().Add(-time.Hour).Unix(),
+		},
+	}, &calls)
+	defer srv.Close()
+
+	i, err := NewIntrospector(IntrospectorOpts{Endpoint: srv.URL, ClientID: "client", ClientSecret: "secret"})
+	assert.Nil(t, err)
+
+	claims, err := i.Introspect(context.Background(), "active-token")
+	assert.Nil(t, err)
+	assert.Equal(t, string(JWTAccess), claims.Type)
+	assert.Equal(t, "42", claims.Subject)
+	assert.Equal(t, uint(42), claims.UserID)
+	assert.Equal(t, "tester", claims.Username)
+	assert.Equal(t, "api", claims.Audience)
+	assert.Equal(t, exp, claims.ExpiresAt)
+	assert.Equal(t, []string{"read", "write"}, claims.Scopes())
+	assert.True(t, claims.HasScope("write"))
+
+	// positive results are cached
+	_, err = i.Introspect(context.Background(), "active-token")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// negative results are cached
+	_, err = i.Introspect(context.Background(), "inactive-token")
+	assert.Equal(t, ErrAuthorizationTokenInvalid, err)
+	_, err = i.Introspect(context.Background(), "inactive-token")
+	assert.Equal(t, ErrAuthorizationTokenInvalid, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// active tokens past their exp are treated as inactive
+	_, err = i.Introspect(context.Background(), "expired-token")
+	assert.Equal(t, ErrAuthorizationTokenInvalid, err)
+
+	// cached results expire
+	i.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, err = i.Introspect(context.Background(), "active-token")
+	assert.Equal(t, ErrAuthorizationTokenInvalid, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	_, err = i.Introspect(context.Background(), "")
+	assert.Equal(t, ErrAuthorizationAccessTokenRequired, err)
+}
+
+func Test_Introspector_Introspect_Audience(t *testing.T) {
+	var calls int32
+
+	srv := newTestIntrospectionServer(t, map[string]map[string]interface{}{
+		"multi-audience-token": {"active": true, "sub": "42", "aud": []string{"web", "api", "admin"}},
+		"other-audience-token": {"active": true, "sub": "42", "aud": []string{"web", "admin"}},
+	}, &calls)
+	defer srv.Close()
+
+	i, err := NewIntrospector(IntrospectorOpts{
+		Endpoint:     srv.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Audience:     "api",
+	})
+	assert.Nil(t, err)
+
+	// the configured audience needn't be the first
+	claims, err := i.Introspect(context.Background(), "multi-audience-token")
+	assert.Nil(t, err)
+	assert.Equal(t, "api", claims.Audience)
+	assert.True(t, claims.VerifyAudience("api", true))
+
+	_, err = i.Introspect(context.Background(), "other-audience-token")
+	assert.Equal(t, ErrAuthorizationTokenInvalid, err)
+}
+
+func Test_Introspector_Introspect_EndpointError(t *testing.T) {
+	var calls int32
+
+	srv := newTestIntrospectionServer(t, nil, &calls)
+	defer srv.Close()
+
+	i, err := NewIntrospector(IntrospectorOpts{Endpoint: srv.URL, ClientID: "client", ClientSecret: "wrong"})
+	assert.Nil(t, err)
+
+	_, err = i.Introspect(context.Background(), "token")
+	assert.True(t, errors.Is(err, ErrIntrospectionFailed))
+	assert.Contains(t, err.Error(), "status 401")
+
+	// failures are not cached
+	_, _ = i.Introspect(context.Background(), "token")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// unreachable endpoints fail the same way
+	srv.Close()
+
+	_, err = i.Introspect(context.Background(), "token")
+	assert.True(t, errors.Is(err, ErrIntrospectionFailed))
+}
+
+func Test_IntrospectionMiddleware_EndpointError(t *testing.T) {
+	var calls int32
+
+	srv := newTestIntrospectionServer(t, nil, &calls)
+	defer srv.Close()
+
+	i, err := NewIntrospector(IntrospectorOpts{Endpoint: srv.URL, ClientID: "client", ClientSecret: "wrong"})
+	assert.Nil(t, err)
+
+	mw, err := ConfigureIntrospectionMiddleware(IntrospectionMiddlewareOpts{Introspector: i})
+	assert.Nil(t, err)
+
+	e := echo.New()
+	e.Use(mw)
+	e.GET("/protected", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer token")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	// outages are told apart from unexpected errors
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"errors":[{
+		"key":"ERR_INTROSPECTION_UNAVAILABLE",
+		"title":"Service Unavailable",
+		"detail":"Token introspection is unavailable"
+	}]}`, rec.Body.String())
+}
+
+func Test_IntrospectionMiddleware(t *testing.T) {
+	var calls int32
+
+	srv := newTestIntrospectionServer(t, map[string]map[string]interface{}{
+		"active-token": {"active": true, "sub": "7", "exp": time.Now().Add(time.Hour).Unix()},
+	}, &calls)
+	defer srv.Close()
+
+	_, err := ConfigureIntrospectionMiddleware(IntrospectionMiddlewareOpts{})
+	assert.Equal(t, ErrNoIntrospector, err)
+
+	i, err := NewIntrospector(IntrospectorOpts{Endpoint: srv.URL, ClientID: "client", ClientSecret: "secret"})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name          string
+		optional      bool
+		authorization string
+		wantStatus    int
+		wantUserID    uint
+	}{
+		{"active", false, "Bearer active-token", http.StatusNoContent, 7},
+		{"inactive", false, "Bearer inactive-token", http.StatusUnauthorized, 0},
+		{"no bearer", false, "active-token", http.StatusUnauthorized, 0},
+		{"missing", false, "", http.StatusUnauthorized, 0},
+		{"optional, missing", true, "", http.StatusNoContent, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mw, err := ConfigureIntrospectionMiddleware(IntrospectionMiddlewareOpts{
+				Introspector: i,
+				Optional:     tt.optional,
+			})
+			assert.Nil(t, err)
+
+			var userID uint
+
+			var token string
+
+			e := echo.New()
+			e.Use(mw)
+			e.GET("/protected", func(c echo.Context) error {
+				if claims, err := GetJWTClaimsFromContext(c.Request().Context()); err == nil {
+					userID = claims.UserID
+				}
+
+				token, _ = GetJWTFromContext(c.Request().Context())
+
+				return c.NoContent(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+			if tt.authorization != "" {
+				req.Header.Set(echo.HeaderAuthorization, tt.authorization)
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantUserID, userID)
+
+			if tt.wantUserID != 0 {
+				assert.Equal(t, "active-token", token)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package webutils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/cyberhorsey/errors"
+	echo "github.com/labstack/echo/v4"
+)
+
+// ipNets is a list of CIDRs
+type ipNets []*net.IPNet
+
+// parseCIDRs parses CIDRs, ie: "10.0.0.0/8", treating single IPs as a CIDR of just that IP
+func parseCIDRs(cidrs []string) (ipNets, error) {
+	nets := make(ipNets, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, errors.Wrapf(ErrInvalidCIDR, "%q", cidr)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidCIDR, "%q", cidr)
+		}
+
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+func (nets ipNets) contains(ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TrustedProxies are the proxies, ie: load balancers and ingress controllers, whose
+// X-Forwarded-For values are trusted
+type TrustedProxies struct {
+	nets ipNets
+}
+
+// NewTrustedProxies returns the TrustedProxies in cidrs, ie: "10.0.0.0/8" or "192.168.1.10"
+func NewTrustedProxies(cidrs ...string) (*TrustedProxies, error) {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TrustedProxies{nets: nets}, nil
+}
+
+// ClientIP returns the IP of the client making r. X-Forwarded-For entries are only trusted when
+// added by a trusted proxy: they're walked from the right, skipping trusted proxies, and the first
+// untrusted IP is the client. Anything a client prepends itself is therefore ignored.
+func (p *TrustedProxies) ClientIP(r *http.Request) string {
+	remoteIP := peerIP(r)
+	if !p.trustsPeer(r) {
+		return remoteIP
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values(echo.HeaderXForwardedFor), ","), ",")
+
+	client := net.ParseIP(remoteIP).String()
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			// the nearest hop we can't parse can't be trusted to have added the rest
+			return client
+		}
+
+		client = hop.String()
+
+		if !p.nets.contains(hop) {
+			return client
+		}
+	}
+
+	// every hop is a trusted proxy, so the furthest is the client
+	return client
+}
+
+// trustsPeer indicates whether r was made directly by a trusted proxy
+func (p *TrustedProxies) trustsPeer(r *http.Request) bool {
+	ip := net.ParseIP(peerIP(r))
+	return ip != nil && p.nets.contains(ip)
+}
+
+// peerIP returns the IP of the directly connected peer
+func peerIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
+// IPExtractor returns ClientIP as an echo.IPExtractor, for c.RealIP(), ie:
+// e.IPExtractor = proxies.IPExtractor()
+func (p *TrustedProxies) IPExtractor() echo.IPExtractor {
+	return p.ClientIP
+}
+
+// ClientIP returns the IP of the client making the request. It is c.RealIP() when the echo
+// instance has an IPExtractor, ie: from TrustedProxies, and otherwise the directly connected peer,
+// as forwarding headers can be set by anyone.
+func ClientIP(c echo.Context) string {
+	if e := c.Echo(); e != nil && e.IPExtractor != nil {
+		return c.RealIP()
+	}
+
+	return echo.ExtractIPDirect()(c.Request())
+}
+
+// IPFilterMiddlewareOpts contains the options for ConfigureIPFilterMiddleware
+type IPFilterMiddlewareOpts struct {
+	Skipper func(c echo.Context) bool
+	// Allow are the CIDRs or IPs requests must come from, ie: office networks. Any IP is allowed
+	// when empty.
+	Allow []string
+	// Deny are the CIDRs or IPs requests may not come from, taking precedence over Allow
+	Deny []string
+	// ClientIP returns the IP requests are filtered by. Defaults to ClientIP.
+	ClientIP func(c echo.Context) string
+}
+
+// ipFilterMiddleware restricts the IPs requests may come from
+type ipFilterMiddleware struct {
+	skipper  func(c echo.Context) bool
+	allow    ipNets
+	deny     ipNets
+	clientIP func(c echo.Context) string
+}
+
+// ConfigureIPFilterMiddleware configures middleware rejecting requests from IPs in opts.Deny with
+// ErrIPDenied, and, when opts.Allow is set, requests from IPs outside it with ErrIPNotAllowed.
+// Configure TrustedProxies when behind a proxy, or every request appears to come from it.
+func ConfigureIPFilterMiddleware(opts IPFilterMiddlewareOpts) (echo.MiddlewareFunc, error) {
+	allow, err := parseCIDRs(opts.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	deny, err := parseCIDRs(opts.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	mw := &ipFilterMiddleware{
+		skipper:  opts.Skipper,
+		allow:    allow,
+		deny:     deny,
+		clientIP: opts.ClientIP,
+	}
+
+	if mw.skipper == nil {
+		mw.skipper = func(c echo.Context) bool { return false }
+	}
+
+	if mw.clientIP == nil {
+		mw.clientIP = ClientIP
+	}
+
+	return mw.Handler, nil
+}
+
+func (mw *ipFilterMiddleware) Handler(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if mw.skipper(c) {
+			return next(c)
+		}
+
+		clientIP := mw.clientIP(c)
+
+		ip := net.ParseIP(clientIP)
+		if ip == nil {
+			return LogAndRenderErrors(c, http.StatusForbidden, errors.Wrapf(ErrIPNotAllowed, "ip %q", clientIP))
+		}
+
+		if mw.deny.contains(ip) {
+			return LogAndRenderErrors(c, http.StatusForbidden, errors.Wrapf(ErrIPDenied, "ip %v", ip))
+		}
+
+		if len(mw.allow) > 0 && !mw.allow.contains(ip) {
+			return LogAndRenderErrors(c, http.StatusForbidden, errors.Wrapf(ErrIPNotAllowed, "ip %v", ip))
+		}
+
+		return next(c)
+	}
+}
@@ -0,0 +1,121 @@
+package webutils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewTrustedProxies(t *testing.T) {
+	_, err := NewTrustedProxies("10.0.0.0/8", "not an ip")
+	assert.True(t, errors.Is(err, ErrInvalidCIDR))
+
+	_, err = NewTrustedProxies("10.0.0.0/33")
+	assert.True(t, errors.Is(err, ErrInvalidCIDR))
+
+	_, err = NewTrustedProxies("10.0.0.0/8", " 192.168.1.10 ", "fd00::/8", "::1")
+	assert.Nil(t, err)
+}
+
+func Test_TrustedProxies_ClientIP(t *testing.T) {
+	proxies, err := NewTrustedProxies("10.0.0.0/8", "192.168.1.10")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		want       string
+	}{
+		{"direct", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted peer's header is ignored", "203.0.113.7:1234", []string{"1.2.3.4"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1:1234", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"spoofed prefix is ignored", "10.0.0.1:1234", []string{"1.2.3.4, 203.0.113.7"}, "203.0.113.7"},
+		{"proxy chain", "10.0.0.1:1234", []string{"203.0.113.7, 192.168.1.10", "10.1.1.1"}, "203.0.113.7"},
+		{"all trusted", "10.0.0.1:1234", []string{"10.2.2.2, 10.1.1.1"}, "10.2.2.2"},
+		{"unparseable hop", "10.0.0.1:1234", []string{"203.0.113.7, garbage, 10.1.1.1"}, "10.1.1.1"},
+		{"no header", "10.0.0.1:1234", nil, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+
+			for _, xff := range tt.xff {
+				req.Header.Add(echo.HeaderXForwardedFor, xff)
+			}
+
+			assert.Equal(t, tt.want, proxies.ClientIP(req))
+
+			e := echo.New()
+			e.IPExtractor = proxies.IPExtractor()
+			assert.Equal(t, tt.want, ClientIP(e.NewContext(req, httptest.NewRecorder())))
+		})
+	}
+
+	// without trusted proxies, forwarding headers are never trusted
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set(echo.HeaderXForwardedFor, "1.2.3.4")
+	req.Header.Set(echo.HeaderXRealIP, "1.2.3.4")
+	assert.Equal(t, "10.0.0.1", ClientIP(echo.New().NewContext(req, httptest.NewRecorder())))
+}
+
+func Test_ConfigureIPFilterMiddleware(t *testing.T) {
+	_, err := ConfigureIPFilterMiddleware(IPFilterMiddlewareOpts{Allow: []string{"office"}})
+	assert.True(t, errors.Is(err, ErrInvalidCIDR))
+
+	_, err = ConfigureIPFilterMiddleware(IPFilterMiddlewareOpts{Deny: []string{"1.2.3.4/99"}})
+	assert.True(t, errors.Is(err, ErrInvalidCIDR))
+
+	mw, err := ConfigureIPFilterMiddleware(IPFilterMiddlewareOpts{
+		Allow: []string{"203.0.113.0/24", "2001:db8::/32"},
+		Deny:  []string{"203.0.113.66"},
+	})
+	assert.Nil(t, err)
+
+	denyOnly, err := ConfigureIPFilterMiddleware(IPFilterMiddlewareOpts{Deny: []string{"198.51.100.0/24"}})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name       string
+		mw         echo.MiddlewareFunc
+		remoteAddr string
+		wantStatus int
+		wantKey    string
+	}{
+		{"allowed", mw, "203.0.113.7:1234", http.StatusNoContent, ""},
+		{"allowed ipv6", mw, "[2001:db8::1]:1234", http.StatusNoContent, ""},
+		{"not allowed", mw, "198.51.100.1:1234", http.StatusForbidden, "ERR_IP_NOT_ALLOWED"},
+		{"denied within allowed", mw, "203.0.113.66:1234", http.StatusForbidden, "ERR_IP_DENIED"},
+		{"unparseable", mw, "garbage", http.StatusForbidden, "ERR_IP_NOT_ALLOWED"},
+		{"deny only allows others", denyOnly, "203.0.113.7:1234", http.StatusNoContent, ""},
+		{"deny only", denyOnly, "198.51.100.1:1234", http.StatusForbidden, "ERR_IP_DENIED"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.GET("/admin", func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }, tt.mw)
+
+			req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+			req.RemoteAddr = tt.remoteAddr
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+
+			if tt.wantKey != "" {
+				errResp := &ErrorResponse{}
+				assert.Nil(t, errResp.UnmarshalJSON(rec.Body.Bytes()))
+				assert.Equal(t, tt.wantKey, errResp.Errors[0].Key)
+			}
+		})
+	}
+}
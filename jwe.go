@@ -0,0 +1,309 @@
+package webutils
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/cyberhorsey/errors"
+)
+
+// JWE algorithms
+const (
+	jweAlgRSAOAEP256 = "RSA-OAEP-256"
+	jweAlgECDHES     = "ECDH-ES"
+	jweEncA256GCM    = "A256GCM"
+	jweContentJWT    = "JWT"
+
+	jweKeySize = 32
+)
+
+// jweHeader is the protected header of a compact JWE
+type jweHeader struct {
+	Alg  string   `json:"alg"`
+	Enc  string   `json:"enc"`
+	Kid  string   `json:"kid,omitempty"`
+	Cty  string   `json:"cty,omitempty"`
+	Epk  *JWK     `json:"epk,omitempty"`
+	Apu  string   `json:"apu,omitempty"`
+	Apv  string   `json:"apv,omitempty"`
+	Zip  string   `json:"zip,omitempty"`
+	Crit []string `json:"crit,omitempty"`
+}
+
+// JWEEncryptionKey is the public key of the recipient tokens are encrypted for
+type JWEEncryptionKey struct {
+	// Kid identifies the key, letting recipients rotate their keys
+	Kid string
+	// Key is an *rsa.PublicKey, for RSA-OAEP-256 key wrapping, or an *ecdsa.PublicKey, for
+	// ECDH-ES key agreement
+	Key crypto.PublicKey
+}
+
+// JWEDecryptionKeys are the private keys, by kid, tokens may be encrypted for. Keys are rotated
+// by adding the new key, encrypting with it, then removing the old key once the tokens encrypted
+// with it have expired. Tokens without a kid are only accepted when there is a single key.
+type JWEDecryptionKeys map[string]crypto.PrivateKey
+
+// CreateEncryptedJWT creates a JWT with CreateJWT, then encrypts it for encryptionKey so its
+// claims can only be read by the holder of the private key, ie: not by browsers.
+func CreateEncryptedJWT(claims Claims, signingKey *rsa.PrivateKey, encryptionKey JWEEncryptionKey) (string, error) {
+	token, err := CreateJWT(claims, signingKey)
+	if err != nil {
+		return "", err
+	}
+
+	return EncryptJWT(token, encryptionKey)
+}
+
+// EncryptJWT encrypts a signed token as a nested compact JWE with A256GCM content encryption
+func EncryptJWT(token string, key JWEEncryptionKey) (string, error) {
+	header := jweHeader{
+		Enc: jweEncA256GCM,
+		Kid: key.Kid,
+		Cty: jweContentJWT,
+	}
+
+	var cek, encryptedKey []byte
+
+	switch pub := key.Key.(type) {
+	case *rsa.PublicKey:
+		header.Alg = jweAlgRSAOAEP256
+
+		cek = make([]byte, jweKeySize)
+		if _, err := rand.Read(cek); err != nil {
+			return "", errors.Wrap(err, "rand.Read")
+		}
+
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, cek, nil)
+		if err != nil {
+			return "", errors.Wrap(err, "rsa.EncryptOAEP")
+		}
+
+		encryptedKey = wrapped
+	case *ecdsa.PublicKey:
+		header.Alg = jweAlgECDHES
+
+		ephemeral, err := ecdsa.GenerateKey(pub.Curve, rand.Reader)
+		if err != nil {
+			return "", errors.Wrap(err, "ecdsa.GenerateKey")
+		}
+
+		epk, err := NewJWK("", &ephemeral.PublicKey)
+		if err != nil {
+			return "", err
+		}
+
+		header.Epk = &epk
+		cek = ecdhesKey(ephemeral, pub, header)
+	default:
+		return "", ErrUnsupportedJWE
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", errors.Wrap(err, "json.Marshal")
+	}
+
+	protected := base64.RawURLEncoding.EncodeToString(headerJSON)
+
+	gcm, err := newJWEGCM(cek)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", errors.Wrap(err, "rand.Read")
+	}
+
+	sealed := gcm.Seal(nil, iv, []byte(token), []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	return strings.Join([]string{
+		protected,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// DecryptJWT decrypts a nested compact JWE, returning the signed token within. The token's
+// signature is not verified; ParseJWT decrypts and verifies when given JWTParseOpts.DecryptionKeys.
+func DecryptJWT(token string, keys JWEDecryptionKeys) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return "", ErrInvalidJWE
+	}
+
+	decoded := make([][]byte, len(parts))
+
+	for i, part := range parts {
+		bs, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return "", ErrInvalidJWE
+		}
+
+		decoded[i] = bs
+	}
+
+	header := jweHeader{}
+	if err := json.Unmarshal(decoded[0], &header); err != nil {
+		return "", ErrInvalidJWE
+	}
+
+	if header.Enc != jweEncA256GCM || header.Zip != "" || len(header.Crit) > 0 {
+		return "", ErrUnsupportedJWE
+	}
+
+	if !strings.EqualFold(header.Cty, jweContentJWT) {
+		return "", errors.Wrapf(ErrUnsupportedJWE, "cty %q", header.Cty)
+	}
+
+	key, err := keys.key(header.Kid)
+	if err != nil {
+		return "", err
+	}
+
+	cek, err := jweContentKey(header, decoded[1], key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newJWEGCM(cek)
+	if err != nil {
+		return "", err
+	}
+
+	if len(decoded[2]) != gcm.NonceSize() || len(decoded[4]) != gcm.Overhead() {
+		return "", ErrInvalidJWE
+	}
+
+	plaintext, err := gcm.Open(nil, decoded[2], append(decoded[3], decoded[4]...), []byte(parts[0]))
+	if err != nil {
+		return "", ErrInvalidJWE
+	}
+
+	return string(plaintext), nil
+}
+
+// key returns the key for kid
+func (keys JWEDecryptionKeys) key(kid string) (crypto.PrivateKey, error) {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownJWEKey, "%q", kid)
+	}
+
+	return key, nil
+}
+
+// jweContentKey unwraps or agrees the content encryption key
+func jweContentKey(header jweHeader, encryptedKey []byte, key crypto.PrivateKey) ([]byte, error) {
+	switch header.Alg {
+	case jweAlgRSAOAEP256:
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, ErrUnsupportedJWE
+		}
+
+		// failures are deliberately indistinguishable to avoid a padding oracle
+		cek, err := rsa.DecryptOAEP(sha256.New(), nil, priv, encryptedKey, nil)
+		if err != nil || len(cek) != jweKeySize {
+			return nil, ErrInvalidJWE
+		}
+
+		return cek, nil
+	case jweAlgECDHES:
+		priv, ok := key.(*ecdsa.PrivateKey)
+		if !ok || header.Epk == nil || len(encryptedKey) != 0 {
+			return nil, ErrInvalidJWE
+		}
+
+		// PublicKey rejects points not on the curve, preventing invalid curve attacks
+		epk, err := header.Epk.PublicKey()
+		if err != nil {
+			return nil, ErrInvalidJWE
+		}
+
+		pub, ok := epk.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != priv.Curve {
+			return nil, ErrInvalidJWE
+		}
+
+		return ecdhesKey(priv, pub, header), nil
+	}
+
+	return nil, errors.Wrapf(ErrUnsupportedJWE, "alg %q", header.Alg)
+}
+
+// ecdhesKey derives the A256GCM key agreed by priv and pub with the RFC 7518 Concat KDF
+func ecdhesKey(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey, header jweHeader) []byte {
+	apu, _ := base64.RawURLEncoding.DecodeString(header.Apu)
+	apv, _ := base64.RawURLEncoding.DecodeString(header.Apv)
+
+	return concatKDF(ecdhZ(priv, pub), header.Enc, apu, apv, jweKeySize)
+}
+
+// ecdhZ returns the ECDH shared secret of priv and pub
+func ecdhZ(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) []byte {
+	size := (priv.Curve.Params().BitSize + 7) / 8
+
+	x, _ := priv.Curve.ScalarMult(pub.X, pub.Y, priv.D.Bytes())
+
+	return x.FillBytes(make([]byte, size))
+}
+
+// concatKDF derives a keySize byte key from z with the NIST SP 800-56A Concat KDF, as profiled by
+// RFC 7518 section 4.6.2. keySize may be at most 32, the output of a single SHA-256 round.
+func concatKDF(z []byte, enc string, apu, apv []byte, keySize int) []byte {
+	h := sha256.New()
+
+	_ = binary.Write(h, binary.BigEndian, uint32(1))
+	_, _ = h.Write(z)
+	writeConcatKDFField(h, []byte(enc))
+	writeConcatKDFField(h, apu)
+	writeConcatKDFField(h, apv)
+	_ = binary.Write(h, binary.BigEndian, uint32(keySize*8))
+
+	return h.Sum(nil)[:keySize]
+}
+
+func writeConcatKDFField(h io.Writer, field []byte) {
+	_ = binary.Write(h, binary.BigEndian, uint32(len(field)))
+	_, _ = h.Write(field)
+}
+
+func newJWEGCM(cek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, errors.Wrap(err, "aes.NewCipher")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "cipher.NewGCM")
+	}
+
+	return gcm, nil
+}
+
+// isJWE indicates whether token is in JWE rather than JWS compact serialization
+func isJWE(token string) bool {
+	return strings.Count(token, ".") == 4
+}
@@ -0,0 +1,338 @@
+package webutils
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cyberhorsey/errors"
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+const defaultJWKSMinRefreshInterval = time.Minute
+
+// JWK is an RFC 7517 JSON Web Key. Only public RSA and EC keys are supported.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is an RFC 7517 JSON Web Key Set
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK returns the JWK of an *rsa.PublicKey or *ecdsa.PublicKey, ie: to publish in a JWKSet
+func NewJWK(kid string, key crypto.PublicKey) (JWK, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		// coordinates are padded to the size of the curve
+		size := (k.Curve.Params().BitSize + 7) / 8
+
+		return JWK{
+			Kty: "EC",
+			Kid: kid,
+			Crv: k.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}, nil
+	}
+
+	return JWK{}, ErrUnsupportedJWK
+}
+
+// PublicKey returns the *rsa.PublicKey or *ecdsa.PublicKey described by the JWK
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, errors.Wrap(err, "decodeJWKInt(k.N)")
+		}
+
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, errors.Wrap(err, "decodeJWKInt(k.E)")
+		}
+
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, ErrInvalidJWK
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, err := jwkCurve(k.Crv)
+		if err != nil {
+			return nil, err
+		}
+
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "decodeJWKInt(k.X)")
+		}
+
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, errors.Wrap(err, "decodeJWKInt(k.Y)")
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, ErrInvalidJWK
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, ErrUnsupportedJWK
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint of the key, as used by DPoP cnf.jkt claims
+func (k JWK) Thumbprint() (string, error) {
+	var members string
+
+	// required members only, in lexicographic order and without whitespace
+	switch k.Kty {
+	case "RSA":
+		if k.N == "" || k.E == "" {
+			return "", ErrInvalidJWK
+		}
+
+		members = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, k.E, k.Kty, k.N)
+	case "EC":
+		if k.Crv == "" || k.X == "" || k.Y == "" {
+			return "", ErrInvalidJWK
+		}
+
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, k.Crv, k.Kty, k.X, k.Y)
+	default:
+		return "", ErrUnsupportedJWK
+	}
+
+	sum := sha256.Sum256([]byte(members))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func jwkCurve(crv string) (elliptic.Curve, error) {
+	switch crv {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	}
+
+	return nil, ErrUnsupportedJWK
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, ErrInvalidJWK
+	}
+
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(bs), nil
+}
+
+// JWKSKeyResolverOpts contains the options for NewJWKSKeyResolver
+type JWKSKeyResolverOpts struct {
+	// URL of the JSON Web Key Set
+	URL string
+	// HTTPClient is used to fetch URL. Defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+	// MinRefreshInterval limits how often an unknown kid can trigger a refetch. Defaults to one
+	// minute.
+	MinRefreshInterval time.Duration
+}
+
+// JWKSKeyResolver resolves token verification keys by kid from a cached JSON Web Key Set,
+// refetching the set when a token references an unknown key.
+type JWKSKeyResolver struct {
+	url                string
+	client             *http.Client
+	minRefreshInterval time.Duration
+	now                func() time.Time
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	refreshedAt time.Time
+}
+
+// NewJWKSKeyResolver creates a JWKSKeyResolver, fetching the key set so configuration errors are
+// reported at startup.
+func NewJWKSKeyResolver(ctx context.Context, opts JWKSKeyResolverOpts) (*JWKSKeyResolver, error) {
+	if opts.URL == "" {
+		return nil, ErrNoJWKSURL
+	}
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if opts.MinRefreshInterval <= 0 {
+		opts.MinRefreshInterval = defaultJWKSMinRefreshInterval
+	}
+
+	r := &JWKSKeyResolver{
+		url:                opts.URL,
+		client:             opts.HTTPClient,
+		minRefreshInterval: opts.MinRefreshInterval,
+		now:                time.Now,
+	}
+
+	if err := r.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	r.refreshedAt = r.now()
+
+	return r, nil
+}
+
+// Refresh refetches the key set
+func (r *JWKSKeyResolver) Refresh(ctx context.Context) error {
+	set := &JWKSet{}
+	if err := getJSON(ctx, r.client, r.url, set); err != nil {
+		return errors.Wrap(err, "getJSON(r.url)")
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+
+	for _, jwk := range set.Keys {
+		// keys for other uses, or of unsupported types, are skipped
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+
+		keys[jwk.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return ErrNoJWKSKeys
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.keys = keys
+
+	return nil
+}
+
+// KeyFunc is a jwt.Keyfunc resolving the token's kid against the key set
+func (r *JWKSKeyResolver) KeyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	if key, ok := r.key(kid); ok {
+		return key, nil
+	}
+
+	// the key may have been rotated in since we last fetched
+	if r.claimRefresh() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := r.Refresh(ctx); err != nil {
+			return nil, err
+		}
+
+		if key, ok := r.key(kid); ok {
+			return key, nil
+		}
+	}
+
+	return nil, ErrUnknownJWK
+}
+
+func (r *JWKSKeyResolver) key(kid string) (crypto.PublicKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.keys[kid]
+	if !ok && kid == "" && len(r.keys) == 1 {
+		// a token without a kid can only be verified against a single key set
+		for _, k := range r.keys {
+			return k, true
+		}
+	}
+
+	return key, ok
+}
+
+// claimRefresh reports whether an unknown kid may trigger a refetch, so that tokens with bogus
+// kids can't be used to hammer the key set URL.
+func (r *JWKSKeyResolver) claimRefresh() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.refreshedAt) < r.minRefreshInterval {
+		return false
+	}
+
+	r.refreshedAt = now
+
+	return true
+}
+
+// getJSON GETs url and decodes the JSON response body into v
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Wrap(err, "http.NewRequestWithContext")
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "client.Do")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v responded %v: %v", url, res.StatusCode, http.StatusText(res.StatusCode))
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return errors.Wrap(err, "json.NewDecoder(res.Body).Decode")
+	}
+
+	return nil
+}
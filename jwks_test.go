@@ -0,0 +1,188 @@
+package webutils
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRSAJWK(kid string, key *rsa.PublicKey) JWK {
+	return JWK{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func newTestECJWK(kid string, key *ecdsa.PublicKey) JWK {
+	return JWK{
+		Kty: "EC",
+		Kid: kid,
+		Crv: key.Curve.Params().Name,
+		X:   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+	}
+}
+
+// newTestJWKSServer serves the key set returned by keys, counting requests
+func newTestJWKSServer(keys func() JWKSet, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		_ = json.NewEncoder(w).Encode(keys())
+	}))
+}
+
+func Test_JWK_PublicKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	key, err := newTestRSAJWK("rsa", &testPrivateKey.PublicKey).PublicKey()
+	assert.Nil(t, err)
+	assert.Equal(t, &testPrivateKey.PublicKey, key)
+
+	key, err = newTestECJWK("ec", &ecKey.PublicKey).PublicKey()
+	assert.Nil(t, err)
+	assert.Equal(t, &ecKey.PublicKey, key)
+
+	offCurve := newTestECJWK("ec", &ecKey.PublicKey)
+	offCurve.Y = offCurve.X
+	_, err = offCurve.PublicKey()
+	assert.Equal(t, ErrInvalidJWK, err)
+
+	_, err = JWK{Kty: "EC", Crv: "P-192"}.PublicKey()
+	assert.Equal(t, ErrUnsupportedJWK, err)
+
+	_, err = JWK{Kty: "oct"}.PublicKey()
+	assert.Equal(t, ErrUnsupportedJWK, err)
+
+	_, err = JWK{Kty: "RSA", N: "!!!", E: "AQAB"}.PublicKey()
+	assert.NotNil(t, err)
+}
+
+func Test_NewJWK(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	assert.Nil(t, err)
+
+	for _, pub := range []interface{}{&testPrivateKey.PublicKey, &ecKey.PublicKey} {
+		jwk, err := NewJWK("kid", pub)
+		assert.Nil(t, err)
+		assert.Equal(t, "kid", jwk.Kid)
+
+		key, err := jwk.PublicKey()
+		assert.Nil(t, err)
+		assert.Equal(t, pub, key)
+	}
+
+	_, err = NewJWK("kid", "not a key")
+	assert.Equal(t, ErrUnsupportedJWK, err)
+}
+
+func Test_JWK_Thumbprint(t *testing.T) {
+	// RFC 7638 section 3.1
+	key := JWK{
+		Kty: "RSA",
+		Kid: "2011-04-29",
+		E:   "AQAB",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiF" +
+			"V4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9" +
+			"c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1j" +
+			"F44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+
+	thumbprint, err := key.Thumbprint()
+	assert.Nil(t, err)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", thumbprint)
+
+	// optional members don't affect the thumbprint
+	key.Kid = ""
+	key.Alg = "RS256"
+	unchanged, err := key.Thumbprint()
+	assert.Nil(t, err)
+	assert.Equal(t, thumbprint, unchanged)
+
+	_, err = JWK{Kty: "EC", Crv: "P-256"}.Thumbprint()
+	assert.Equal(t, ErrInvalidJWK, err)
+
+	_, err = JWK{Kty: "oct"}.Thumbprint()
+	assert.Equal(t, ErrUnsupportedJWK, err)
+}
+
+func Test_JWKSKeyResolver(t *testing.T) {
+	var calls int32
+
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	var mu sync.Mutex
+
+	set := JWKSet{Keys: []JWK{
+		newTestRSAJWK("k1", &testPrivateKey.PublicKey),
+		{Kty: "RSA", Kid: "enc", Use: "enc"},
+	}}
+
+	srv := newTestJWKSServer(func() JWKSet {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return set
+	}, &calls)
+	defer srv.Close()
+
+	_, err = NewJWKSKeyResolver(context.Background(), JWKSKeyResolverOpts{})
+	assert.Equal(t, ErrNoJWKSURL, err)
+
+	r, err := NewJWKSKeyResolver(context.Background(), JWKSKeyResolverOpts{URL: srv.URL})
+	assert.Nil(t, err)
+
+	token := &jwt.Token{Header: map[string]interface{}{"kid": "k1"}}
+	key, err := r.KeyFunc(token)
+	assert.Nil(t, err)
+	assert.Equal(t, &testPrivateKey.PublicKey, key)
+
+	// the only key is used for tokens without a kid
+	key, err = r.KeyFunc(&jwt.Token{Header: map[string]interface{}{}})
+	assert.Nil(t, err)
+	assert.Equal(t, &testPrivateKey.PublicKey, key)
+
+	// unknown kids don't refetch within the minimum refresh interval
+	mu.Lock()
+	set.Keys = append(set.Keys, newTestRSAJWK("k2", &rotated.PublicKey))
+	mu.Unlock()
+
+	_, err = r.KeyFunc(&jwt.Token{Header: map[string]interface{}{"kid": "k2"}})
+	assert.Equal(t, ErrUnknownJWK, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// once it has passed, the rotated key is fetched
+	r.now = func() time.Time { return time.Now().Add(2 * defaultJWKSMinRefreshInterval) }
+	key, err = r.KeyFunc(&jwt.Token{Header: map[string]interface{}{"kid": "k2"}})
+	assert.Nil(t, err)
+	assert.Equal(t, &rotated.PublicKey, key)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func Test_JWKSKeyResolver_NoKeys(t *testing.T) {
+	var calls int32
+
+	srv := newTestJWKSServer(func() JWKSet { return JWKSet{} }, &calls)
+	defer srv.Close()
+
+	_, err := NewJWKSKeyResolver(context.Background(), JWKSKeyResolverOpts{URL: srv.URL})
+	assert.Equal(t, ErrNoJWKSKeys, err)
+}
@@ -30,6 +30,9 @@ const (
 
 	// JWTRefresh is a long-lived refresh token used to gain access tokens
 	JWTRefresh JWTType = "refresh"
+
+	// JWTSession is a session token kept in a cookie, see SessionManager
+	JWTSession JWTType = "session"
 )
 
 // Claims contains jwt.Token.Claims data
@@ -38,6 +41,33 @@ type Claims struct {
 	Type     string `json:"type"`
 	UserID   uint   `json:"user_id"`
 	Username string `json:"username"`
+	Scope    string `json:"scope,omitempty"`
+	// TenantID is the tenant, or account, the token was issued for, see TenantFromContext
+	TenantID string `json:"tenant_id,omitempty"`
+	// AuthTime is when the subject authenticated, which bounds session renewals
+	AuthTime int64 `json:"auth_time,omitempty"`
+	// SessionID is kept across the renewals of a session, which are revoked together on logout
+	SessionID string `json:"sid,omitempty"`
+	// Actor is who is acting on behalf of the subject, see TokenExchanger
+	Actor *Actor `json:"act,omitempty"`
+	// Confirmation binds the token to a DPoP key, see BindDPoPKey
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+}
+
+// Scopes returns the space-delimited Scope as a slice
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope indicates whether the claims were granted scope
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
 }
 
 // AuthorizedUserID returns the authorized UserID from the claims
@@ -54,7 +84,8 @@ func (c *Claims) Authorized() error {
 	return nil
 }
 
-// CreateJWT creates a JWT string for the provided Claims, signed with the RSA key.
+// CreateJWT creates a JWT string for the provided Claims, signed with the RSA key. Claims bound to
+// a key with BindDPoPKey create a DPoP-bound token.
 func CreateJWT(claims Claims, key *rsa.PrivateKey) (string, error) {
 	if err := claims.Valid(); err != nil {
 		return "", errors.Wrap(err, "claims.Valid()")
@@ -79,11 +110,56 @@ func GetClaimsFromJWT(token string, key *rsa.PublicKey) (*Claims, error) {
 		return nil, ErrNoKey
 	}
 
+	return ParseJWT(token, JWTParseOpts{
+		KeyFunc: func(token *jwt.Token) (interface{}, error) {
+			return key, nil
+		},
+	})
+}
+
+// JWTParseOpts contains the options for ParseJWT
+type JWTParseOpts struct {
+	// KeyFunc resolves the key used to verify the token signature
+	KeyFunc jwt.Keyfunc
+	// Issuer, when set, must match the token's iss claim
+	Issuer string
+	// Audience, when set, must be contained in the token's aud claim
+	Audience string
+	// SigningMethods restricts the accepted alg header values. When empty, any method matching
+	// the type of the resolved key is accepted.
+	SigningMethods []string
+	// DecryptionKeys, when set, decrypt encrypted tokens before they are verified, see
+	// CreateEncryptedJWT
+	DecryptionKeys JWEDecryptionKeys
+}
+
+// ParseJWT parses and validates the token string, returning its Claims
+func ParseJWT(token string, opts JWTParseOpts) (*Claims, error) {
+	if token == "" {
+		return nil, ErrNoToken
+	}
+
+	if opts.KeyFunc == nil {
+		return nil, ErrNoKey
+	}
+
+	if opts.DecryptionKeys != nil && isJWE(token) {
+		decrypted, err := DecryptJWT(token, opts.DecryptionKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		token = decrypted
+	}
+
 	claims := &Claims{}
 
-	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return key, nil
-	})
+	var parserOpts []jwt.ParserOption
+	if len(opts.SigningMethods) > 0 {
+		parserOpts = append(parserOpts, jwt.WithValidMethods(opts.SigningMethods))
+	}
+
+	parsedToken, err := jwt.NewParser(parserOpts...).ParseWithClaims(token, claims, opts.KeyFunc)
 	if err != nil {
 		return nil, err
 	}
@@ -92,6 +168,14 @@ func GetClaimsFromJWT(token string, key *rsa.PublicKey) (*Claims, error) {
 		return nil, ErrInvalidToken
 	}
 
+	if opts.Issuer != "" && !claims.VerifyIssuer(opts.Issuer, true) {
+		return nil, ErrInvalidTokenIssuer
+	}
+
+	if opts.Audience != "" && !claims.VerifyAudience(opts.Audience, true) {
+		return nil, ErrInvalidTokenAudience
+	}
+
 	return claims, nil
 }
 
@@ -131,18 +215,55 @@ var defaultJWTMiddlewareSkipper = func(c echo.Context) bool {
 type JWTMiddlewareOpts struct {
 	PublicKey func(c echo.Context) (*rsa.PublicKey, error)
 	Skipper   func(c echo.Context) bool
+	// PublicRoutes are route patterns, such as "GET /docs/*", skipped in addition to those
+	// Skipper skips. See RouteMatcher.
+	PublicRoutes []string
+	// KeyFunc resolves the verification key per token, ie: from a JWKS, and takes precedence over
+	// PublicKey
+	KeyFunc jwt.Keyfunc
+	// Issuer, Audience and SigningMethods further restrict the accepted tokens, see JWTParseOpts
+	Issuer         string
+	Audience       string
+	SigningMethods []string
+	// DecryptionKeys lets encrypted tokens be accepted alongside signed ones, see JWTParseOpts
+	DecryptionKeys JWEDecryptionKeys
+	// AllowUntypedTokens accepts tokens without a type claim, such as those issued by external
+	// identity providers. Tokens typed as anything other than JWTAccess are still rejected.
+	AllowUntypedTokens bool
+	// Realm is sent in the WWW-Authenticate challenge of rejected requests
+	Realm string
+	// DPoP, when set, accepts DPoP-bound tokens presented with the DPoP authorization scheme.
+	// Tokens with a cnf claim are rejected without a valid proof either way.
+	DPoP *DPoPVerifier
+	// Optional lets requests without an Authorization header through without claims. Valid tokens
+	// still populate the context as usual.
+	Optional bool
+	// IgnoreInvalidTokens, when Optional is set, lets requests with a malformed, expired or
+	// otherwise invalid token through without claims instead of rejecting them.
+	IgnoreInvalidTokens bool
 }
 
 // jwtMiddleware is a wrapper for echo jwt middleware
 type jwtMiddleware struct {
-	PublicKey func(c echo.Context) (*rsa.PublicKey, error)
-	Skipper   func(c echo.Context) bool
+	PublicKey           func(c echo.Context) (*rsa.PublicKey, error)
+	Skipper             func(c echo.Context) bool
+	PublicRoutes        []string
+	KeyFunc             jwt.Keyfunc
+	Issuer              string
+	Audience            string
+	SigningMethods      []string
+	DecryptionKeys      JWEDecryptionKeys
+	AllowUntypedTokens  bool
+	Realm               string
+	DPoP                *DPoPVerifier
+	Optional            bool
+	IgnoreInvalidTokens bool
 }
 
 // ConfigureJWTMiddleware configures JWT middleware
 func ConfigureJWTMiddleware(opts JWTMiddlewareOpts) (echo.MiddlewareFunc, error) {
 	mw := jwtMiddleware(opts)
-	if mw.PublicKey == nil {
+	if mw.PublicKey == nil && mw.KeyFunc == nil {
 		return nil, ErrNoPublicKeyFunction
 	}
 
@@ -150,6 +271,18 @@ func ConfigureJWTMiddleware(opts JWTMiddlewareOpts) (echo.MiddlewareFunc, error)
 		mw.Skipper = defaultJWTMiddlewareSkipper
 	}
 
+	if len(mw.PublicRoutes) > 0 {
+		publicRoutes, err := NewRouteMatcher(mw.PublicRoutes...)
+		if err != nil {
+			return nil, err
+		}
+
+		skipper := mw.Skipper
+		mw.Skipper = func(c echo.Context) bool {
+			return publicRoutes.Match(c) || skipper(c)
+		}
+	}
+
 	return mw.Handler, nil
 }
 
@@ -162,7 +295,16 @@ func (mw *jwtMiddleware) Handler(
 			return next(c)
 		}
 
-		pk, err := mw.PublicKey(c)
+		c.Set(contextKeyAuthRealm, mw.Realm)
+
+		header := c.Request().Header.Get(echo.HeaderAuthorization)
+
+		// anonymous access is allowed in optional mode
+		if header == "" && mw.Optional {
+			return next(c)
+		}
+
+		keyFunc, err := mw.keyFunc(c)
 		if err != nil {
 			if errors.GetType(err) != errors.NoType {
 				return LogAndRenderErrors(c, ConvertErrorToStatusCode(err), err)
@@ -171,27 +313,106 @@ func (mw *jwtMiddleware) Handler(
 			return LogAndRenderUnexpectedError(c, err)
 		}
 
-		claims, err := GetClaimsFromBearerJWT(c.Request().Header.Get("Authorization"), pk)
+		claims, token, err := mw.authenticate(c, header, JWTParseOpts{
+			KeyFunc:        keyFunc,
+			Issuer:         mw.Issuer,
+			Audience:       mw.Audience,
+			SigningMethods: mw.SigningMethods,
+			DecryptionKeys: mw.DecryptionKeys,
+		})
 		if err != nil {
-			return LogAndRenderErrors(c, http.StatusUnauthorized, errors.Wrap(err, "GetClaimsFromBearerJWT"))
-		}
+			if mw.ignoreInvalidToken() {
+				return next(c)
+			}
 
-		if claims.Type != string(JWTAccess) {
-			return LogAndRenderErrors(c, http.StatusUnauthorized, ErrAuthorizationAccessTokenRequired)
+			mw.setChallenge(c, header, err)
+
+			return LogAndRenderErrors(c, http.StatusUnauthorized, err)
 		}
 
-		c.Set(ContextKeyJWTClaims, claims)
-		ctx := context.WithValue(c.Request().Context(), ContextKey(ContextKeyJWTClaims), claims)
+		setJWTContext(c, claims, token)
 
-		header := c.Request().Header.Get(echo.HeaderAuthorization)
-		jwt := header[len(bearerPrefix):]
-		c.Set(ContextKeyJWT, jwt)
-		ctx = context.WithValue(ctx, ContextKey(ContextKeyJWT), jwt)
+		return next(c)
+	}
+}
 
-		c.SetRequest(c.Request().WithContext(ctx))
+// authenticate validates the Bearer token in header or, when DPoP is configured, a DPoP token
+func (mw *jwtMiddleware) authenticate(c echo.Context, header string, opts JWTParseOpts) (*Claims, string, error) {
+	if mw.DPoP != nil && strings.HasPrefix(header, dpopPrefix) {
+		return mw.DPoP.authenticate(c, header[len(dpopPrefix):], opts, mw.AllowUntypedTokens)
+	}
 
-		return next(c)
+	return authenticateBearerJWT(header, opts, mw.AllowUntypedTokens)
+}
+
+// setChallenge sets the WWW-Authenticate header for a request rejected with err, using the DPoP
+// scheme for DPoP requests and bound tokens presented without one
+func (mw *jwtMiddleware) setChallenge(c echo.Context, header string, err error) {
+	err = challengeError(header, err)
+
+	isDPoP := strings.HasPrefix(header, dpopPrefix) || isDPoPProofError(err)
+	if mw.DPoP != nil && isDPoP {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, mw.DPoP.challenge(mw.Realm, err))
+		return
 	}
+
+	setBearerChallenge(c, mw.Realm, err)
+}
+
+// keyFunc returns the KeyFunc, or wraps the PublicKey for the request in one
+func (mw *jwtMiddleware) keyFunc(c echo.Context) (jwt.Keyfunc, error) {
+	if mw.KeyFunc != nil {
+		return mw.KeyFunc, nil
+	}
+
+	pk, err := mw.PublicKey(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return publicKeyFunc(pk), nil
+}
+
+// publicKeyFunc returns a jwt.Keyfunc always resolving to pk
+func publicKeyFunc(pk *rsa.PublicKey) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if pk == nil {
+			return nil, ErrNoKey
+		}
+
+		return pk, nil
+	}
+}
+
+// ignoreInvalidToken indicates whether a request with an invalid token should continue without
+// claims rather than being rejected.
+func (mw *jwtMiddleware) ignoreInvalidToken() bool {
+	return mw.Optional && mw.IgnoreInvalidTokens
+}
+
+// setJWTContext stores the claims and raw token on the echo.Context and its request context.
+func setJWTContext(c echo.Context, claims *Claims, jwt string) {
+	setJWTClaimsContext(c, claims)
+
+	c.Set(ContextKeyJWT, jwt)
+	c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), ContextKey(ContextKeyJWT), jwt)))
+}
+
+// setJWTClaimsContext stores only the claims on the echo.Context and its request context, for
+// credentials which must never be forwarded as a bearer token, ie: session cookies and API keys.
+func setJWTClaimsContext(c echo.Context, claims *Claims) {
+	c.Set(ContextKeyJWTClaims, claims)
+
+	c.SetRequest(c.Request().WithContext(
+		context.WithValue(c.Request().Context(), ContextKey(ContextKeyJWTClaims), claims),
+	))
+}
+
+// newJWTContext returns a copy of ctx carrying the claims and raw token.
+func newJWTContext(ctx context.Context, claims *Claims, jwt string) context.Context {
+	ctx = context.WithValue(ctx, ContextKey(ContextKeyJWTClaims), claims)
+
+	return context.WithValue(ctx, ContextKey(ContextKeyJWT), jwt)
 }
 
 const (
@@ -203,21 +424,80 @@ func GetClaimsFromBearerJWT(
 	token string,
 	jwtPublicKey *rsa.PublicKey,
 ) (*Claims, error) {
-	if token == "" {
+	jwt, err := getBearerToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := GetClaimsFromJWT(jwt, jwtPublicKey)
+	if err != nil {
+		return nil, errors.WithCause(ErrAuthorizationTokenInvalid, err)
+	}
+
+	return claims, nil
+}
+
+// authenticateBearerJWT validates the access token in a Bearer Authorization header value,
+// returning its claims and the raw token. It is shared by the JWT middleware and gRPC interceptors.
+func authenticateBearerJWT(
+	header string,
+	opts JWTParseOpts,
+	allowUntypedTokens bool,
+) (*Claims, string, error) {
+	token, err := getBearerToken(header)
+	if err != nil {
+		return nil, "", err
+	}
+
+	claims, err := authenticateJWT(token, opts, allowUntypedTokens)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// a token bound to a key is only accepted with a proof of possession
+	if claims.Confirmation != nil {
+		return nil, "", ErrAuthorizationDPoPProofRequired
+	}
+
+	return claims, token, nil
+}
+
+// authenticateJWT validates an access token, returning its claims
+func authenticateJWT(token string, opts JWTParseOpts, allowUntypedTokens bool) (*Claims, error) {
+	claims, err := ParseJWT(token, opts)
+	if err != nil {
+		return nil, errors.WithCause(ErrAuthorizationTokenInvalid, err)
+	}
+
+	if claims.Type != string(JWTAccess) && !(claims.Type == "" && allowUntypedTokens) {
 		return nil, ErrAuthorizationAccessTokenRequired
 	}
 
-	if strings.HasPrefix(token, bearerPrefix) {
-		claims, err := GetClaimsFromJWT(token[len(bearerPrefix):], jwtPublicKey)
-		if err != nil {
-			return nil, errors.WithCause(ErrAuthorizationTokenInvalid, err)
-		}
+	return claims, nil
+}
 
-		return claims, nil
+// challengeError returns the error to challenge a request with, which is nil when the request
+// carried no credentials at all
+func challengeError(header string, err error) error {
+	if header == "" {
+		return nil
+	}
+
+	return err
+}
+
+// getBearerToken returns the token from a Bearer Authorization header value
+func getBearerToken(header string) (string, error) {
+	if header == "" {
+		return "", ErrAuthorizationAccessTokenRequired
 	}
 
 	// enforce Bearer prefix
-	return nil, ErrAuthorizationBearerRequired
+	if !strings.HasPrefix(header, bearerPrefix) {
+		return "", ErrAuthorizationBearerRequired
+	}
+
+	return header[len(bearerPrefix):], nil
 }
 
 // GetJWTClaimsFromEchoContext retrieves the *webutils.Claims from the provided echo.Context.
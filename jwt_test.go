@@ -0,0 +1,184 @@
+package webutils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+var testPrivateKey = func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	return key
+}()
+
+func newTestClaims(tokenType JWTType, ttl time.Duration) Claims {
+	return Claims{
+		Type:     string(tokenType),
+		UserID:   1,
+		Username: "tester",
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    "cyberhorsey",
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+		},
+	}
+}
+
+func newTestJWT(t *testing.T, claims Claims) string {
+	token, err := CreateJWT(claims, testPrivateKey)
+	if err != nil {
+		t.Fatalf("CreateJWT: %v", err)
+	}
+
+	return token
+}
+
+func newTestExpiredJWT(t *testing.T) string {
+	claims := newTestClaims(JWTAccess, time.Hour)
+	claims.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS512, claims).SignedString(testPrivateKey)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+
+	return token
+}
+
+func testPublicKeyFunc(c echo.Context) (*rsa.PublicKey, error) {
+	return &testPrivateKey.PublicKey, nil
+}
+
+// serveJWTMiddleware runs a request through mw and reports the claims the handler saw
+func serveJWTMiddleware(
+	t *testing.T,
+	mw echo.MiddlewareFunc,
+	authorization string,
+) (*httptest.ResponseRecorder, *Claims) {
+	var claims *Claims
+
+	e := echo.New()
+	e.Use(mw)
+	e.GET("/protected", func(c echo.Context) error {
+		claims, _ = GetJWTClaimsFromContext(c.Request().Context())
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	if authorization != "" {
+		req.Header.Set(echo.HeaderAuthorization, authorization)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	return rec, claims
+}
+
+func Test_ConfigureJWTMiddleware_NoPublicKey(t *testing.T) {
+	_, err := ConfigureJWTMiddleware(JWTMiddlewareOpts{})
+	assert.Equal(t, ErrNoPublicKeyFunction, err)
+}
+
+func Test_JWTMiddleware(t *testing.T) {
+	valid := newTestJWT(t, newTestClaims(JWTAccess, time.Hour))
+	refresh := newTestJWT(t, newTestClaims(JWTRefresh, time.Hour))
+	expired := newTestExpiredJWT(t)
+
+	tests := []struct {
+		name          string
+		optional      bool
+		ignoreInvalid bool
+		authorization string
+		wantStatus    int
+		wantClaims    bool
+	}{
+		{"required, valid", false, false, "Bearer " + valid, http.StatusNoContent, true},
+		{"required, missing", false, false, "", http.StatusUnauthorized, false},
+		{"required, expired", false, false, "Bearer " + expired, http.StatusUnauthorized, false},
+		{"required, refresh", false, false, "Bearer " + refresh, http.StatusUnauthorized, false},
+		{"required, no bearer", false, false, valid, http.StatusUnauthorized, false},
+		{"optional, valid", true, false, "Bearer " + valid, http.StatusNoContent, true},
+		{"optional, missing", true, false, "", http.StatusNoContent, false},
+		{"optional, expired", true, false, "Bearer " + expired, http.StatusUnauthorized, false},
+		{"optional, malformed", true, false, "Bearer malformed", http.StatusUnauthorized, false},
+		{"optional ignore invalid, valid", true, true, "Bearer " + valid, http.StatusNoContent, true},
+		{"optional ignore invalid, missing", true, true, "", http.StatusNoContent, false},
+		{"optional ignore invalid, expired", true, true, "Bearer " + expired, http.StatusNoContent, false},
+		{"optional ignore invalid, malformed", true, true, "Bearer malformed", http.StatusNoContent, false},
+		{"optional ignore invalid, refresh", true, true, "Bearer " + refresh, http.StatusNoContent, false},
+		{"ignore invalid without optional", false, true, "Bearer malformed", http.StatusUnauthorized, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mw, err := ConfigureJWTMiddleware(JWTMiddlewareOpts{
+				PublicKey:           testPublicKeyFunc,
+				Optional:            tt.optional,
+				IgnoreInvalidTokens: tt.ignoreInvalid,
+			})
+			assert.Nil(t, err)
+
+			rec, claims := serveJWTMiddleware(t, mw, tt.authorization)
+			assert.Equal(t, tt.wantStatus, rec.Code)
+
+			if tt.wantClaims {
+				assert.NotNil(t, claims)
+				assert.Equal(t, uint(1), claims.UserID)
+			} else {
+				assert.Nil(t, claims)
+			}
+		})
+	}
+}
+
+func Test_ParseJWT(t *testing.T) {
+	claims := newTestClaims(JWTAccess, time.Hour)
+	claims.Audience = "api"
+	token := newTestJWT(t, claims)
+
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		return &testPrivateKey.PublicKey, nil
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		opts    JWTParseOpts
+		wantErr bool
+	}{
+		{"valid", token, JWTParseOpts{KeyFunc: keyFunc}, false},
+		{"no token", "", JWTParseOpts{KeyFunc: keyFunc}, true},
+		{"no key func", token, JWTParseOpts{}, true},
+		{"issuer", token, JWTParseOpts{KeyFunc: keyFunc, Issuer: "cyberhorsey"}, false},
+		{"wrong issuer", token, JWTParseOpts{KeyFunc: keyFunc, Issuer: "other"}, true},
+		{"audience", token, JWTParseOpts{KeyFunc: keyFunc, Audience: "api"}, false},
+		{"wrong audience", token, JWTParseOpts{KeyFunc: keyFunc, Audience: "other"}, true},
+		{"signing method", token, JWTParseOpts{KeyFunc: keyFunc, SigningMethods: []string{"RS512"}}, false},
+		{"wrong signing method", token, JWTParseOpts{KeyFunc: keyFunc, SigningMethods: []string{"RS256"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseJWT(tt.token, tt.opts)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, claims.UserID, got.UserID)
+		})
+	}
+}
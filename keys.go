@@ -0,0 +1,373 @@
+package webutils
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cyberhorsey/errors"
+	echo "github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultKeyWatchInterval = 10 * time.Second
+
+// PEM block types
+const (
+	pemTypeRSAPrivateKey = "RSA PRIVATE KEY"
+	pemTypePrivateKey    = "PRIVATE KEY"
+	pemTypeRSAPublicKey  = "RSA PUBLIC KEY"
+	pemTypePublicKey     = "PUBLIC KEY"
+	pemTypeCertificate   = "CERTIFICATE"
+)
+
+// ParseRSAPrivateKeyPEM parses a PKCS#1 ("RSA PRIVATE KEY") or PKCS#8 ("PRIVATE KEY") PEM
+// encoded RSA private key.
+func ParseRSAPrivateKeyPEM(data []byte) (*rsa.PrivateKey, error) {
+	block, err := decodePEM(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case pemTypeRSAPrivateKey:
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "x509.ParsePKCS1PrivateKey")
+		}
+
+		return key, nil
+	case pemTypePrivateKey:
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "x509.ParsePKCS8PrivateKey")
+		}
+
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, ErrKeyNotRSA
+		}
+
+		return rsaKey, nil
+	}
+
+	return nil, errors.Wrapf(ErrUnsupportedPEMType, "%q", block.Type)
+}
+
+// ParseRSAPublicKeyPEM parses a PKCS#1 ("RSA PUBLIC KEY"), PKIX ("PUBLIC KEY") or certificate
+// ("CERTIFICATE") PEM encoded RSA public key.
+func ParseRSAPublicKeyPEM(data []byte) (*rsa.PublicKey, error) {
+	block, err := decodePEM(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+
+	switch block.Type {
+	case pemTypeRSAPublicKey:
+		rsaKey, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "x509.ParsePKCS1PublicKey")
+		}
+
+		return rsaKey, nil
+	case pemTypePublicKey:
+		if key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, errors.Wrap(err, "x509.ParsePKIXPublicKey")
+		}
+	case pemTypeCertificate:
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "x509.ParseCertificate")
+		}
+
+		key = cert.PublicKey
+	default:
+		return nil, errors.Wrapf(ErrUnsupportedPEMType, "%q", block.Type)
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrKeyNotRSA
+	}
+
+	return rsaKey, nil
+}
+
+// decodePEM decodes the first PEM block in data, which may also be base64 encoded PEM as is
+// common for keys in environment variables
+func decodePEM(data []byte) (*pem.Block, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, ErrNoPEMBlock
+	}
+
+	if !bytes.HasPrefix(data, []byte("-----BEGIN")) {
+		// unlike Base64Decode, invalid base64 is reported rather than silently truncated
+		decoded, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, errors.WithCause(ErrNoPEMBlock, err)
+		}
+
+		data = decoded
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoPEMBlock
+	}
+
+	return block, nil
+}
+
+// LoadRSAPrivateKeyFile loads a PEM encoded RSA private key from path
+func LoadRSAPrivateKeyFile(path string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "ioutil.ReadFile")
+	}
+
+	key, err := ParseRSAPrivateKeyPEM(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ParseRSAPrivateKeyPEM(%v)", path)
+	}
+
+	return key, nil
+}
+
+// LoadRSAPublicKeyFile loads a PEM encoded RSA public key or certificate from path
+func LoadRSAPublicKeyFile(path string) (*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "ioutil.ReadFile")
+	}
+
+	key, err := ParseRSAPublicKeyPEM(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ParseRSAPublicKeyPEM(%v)", path)
+	}
+
+	return key, nil
+}
+
+// LoadRSAPrivateKeyEnv loads an RSA private key from the environment variable name, which may
+// hold the PEM itself, with literal "\n" newlines, or base64 encoded PEM.
+func LoadRSAPrivateKeyEnv(name string) (*rsa.PrivateKey, error) {
+	data, err := keyEnv(name)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParseRSAPrivateKeyPEM(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ParseRSAPrivateKeyPEM($%v)", name)
+	}
+
+	return key, nil
+}
+
+// LoadRSAPublicKeyEnv loads an RSA public key or certificate from the environment variable
+// name, which may hold the PEM itself, with literal "\n" newlines, or base64 encoded PEM.
+func LoadRSAPublicKeyEnv(name string) (*rsa.PublicKey, error) {
+	data, err := keyEnv(name)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParseRSAPublicKeyPEM(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ParseRSAPublicKeyPEM($%v)", name)
+	}
+
+	return key, nil
+}
+
+func keyEnv(name string) ([]byte, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return nil, errors.Wrapf(ErrKeyEnvNotSet, "$%v", name)
+	}
+
+	return []byte(strings.ReplaceAll(value, `\n`, "\n")), nil
+}
+
+// RSAKeyFileWatcherOpts contains the options for the RSA key file watchers
+type RSAKeyFileWatcherOpts struct {
+	// Path of the PEM file, ie: a mounted Kubernetes secret
+	Path string
+	// Interval between checks for changes. Defaults to 10 seconds.
+	Interval time.Duration
+}
+
+// keyFileWatcher polls a key file, atomically swapping in the parsed key when its contents
+// change. Polling, rather than file system events, copes with Kubernetes replacing mounted
+// secrets by swapping symlinks.
+type keyFileWatcher struct {
+	path     string
+	interval time.Duration
+	parse    func(data []byte) (interface{}, error)
+	key      atomic.Value
+	contents []byte
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newKeyFileWatcher(
+	ctx context.Context,
+	opts RSAKeyFileWatcherOpts,
+	parse func(data []byte) (interface{}, error),
+) (*keyFileWatcher, error) {
+	if opts.Path == "" {
+		return nil, ErrNoKeyFilePath
+	}
+
+	if opts.Interval <= 0 {
+		opts.Interval = defaultKeyWatchInterval
+	}
+
+	w := &keyFileWatcher{
+		path:     opts.Path,
+		interval: opts.Interval,
+		parse:    parse,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+
+	// a startup or request ctx must not end the reloads keys are rotated by
+	go w.watch(Detach(ctx))
+
+	return w, nil
+}
+
+func (w *keyFileWatcher) watch(ctx context.Context) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			// a bad rotation keeps the current key in use
+			if err := w.reload(); err != nil {
+				logger.WithFields(logFields(ctx)).WithFields(logrus.Fields{"path": w.path}).
+					Error(errors.Wrap(err, "w.reload"))
+			}
+		}
+	}
+}
+
+// close stops watching, returning once no more reloads can happen
+func (w *keyFileWatcher) close() {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+	})
+
+	<-w.done
+}
+
+// reload parses the file if its contents have changed. It is only called by one goroutine at a
+// time.
+func (w *keyFileWatcher) reload() error {
+	data, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		return errors.Wrap(err, "ioutil.ReadFile")
+	}
+
+	if w.contents != nil && bytes.Equal(data, w.contents) {
+		return nil
+	}
+
+	key, err := w.parse(data)
+	if err != nil {
+		return errors.Wrapf(err, "parse(%v)", w.path)
+	}
+
+	w.key.Store(key)
+	w.contents = data
+
+	return nil
+}
+
+// RSAPublicKeyFileWatcher holds an RSA public key loaded from a file, reloading it when the file
+// changes.
+type RSAPublicKeyFileWatcher struct {
+	w *keyFileWatcher
+}
+
+// NewRSAPublicKeyFileWatcher loads the public key at opts.Path and watches it for changes until
+// Close is called. ctx's values are logged with reload errors, but its cancellation doesn't stop
+// the watching.
+func NewRSAPublicKeyFileWatcher(ctx context.Context, opts RSAKeyFileWatcherOpts) (*RSAPublicKeyFileWatcher, error) {
+	w, err := newKeyFileWatcher(ctx, opts, func(data []byte) (interface{}, error) {
+		return ParseRSAPublicKeyPEM(data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RSAPublicKeyFileWatcher{w: w}, nil
+}
+
+// Key returns the current public key
+func (w *RSAPublicKeyFileWatcher) Key() *rsa.PublicKey {
+	return w.w.key.Load().(*rsa.PublicKey)
+}
+
+// PublicKey returns the current public key, for use as JWTMiddlewareOpts.PublicKey
+func (w *RSAPublicKeyFileWatcher) PublicKey(c echo.Context) (*rsa.PublicKey, error) {
+	return w.Key(), nil
+}
+
+// Close stops watching the file; the current key stays in use
+func (w *RSAPublicKeyFileWatcher) Close() {
+	w.w.close()
+}
+
+// RSAPrivateKeyFileWatcher holds an RSA private key loaded from a file, reloading it when the
+// file changes.
+type RSAPrivateKeyFileWatcher struct {
+	w *keyFileWatcher
+}
+
+// NewRSAPrivateKeyFileWatcher loads the private key at opts.Path and watches it for changes
+// until Close is called. ctx's values are logged with reload errors, but its cancellation doesn't
+// stop the watching.
+func NewRSAPrivateKeyFileWatcher(ctx context.Context, opts RSAKeyFileWatcherOpts) (*RSAPrivateKeyFileWatcher, error) {
+	w, err := newKeyFileWatcher(ctx, opts, func(data []byte) (interface{}, error) {
+		return ParseRSAPrivateKeyPEM(data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RSAPrivateKeyFileWatcher{w: w}, nil
+}
+
+// Key returns the current private key, ie: for CreateJWT
+func (w *RSAPrivateKeyFileWatcher) Key() *rsa.PrivateKey {
+	return w.w.key.Load().(*rsa.PrivateKey)
+}
+
+// Close stops watching the file; the current key stays in use
+func (w *RSAPrivateKeyFileWatcher) Close() {
+	w.w.close()
+}
@@ -0,0 +1,258 @@
+package webutils
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeTestPEM(t *testing.T, blockType string, der []byte, err error) []byte {
+	if err != nil {
+		t.Fatalf("encoding %v: %v", blockType, err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func newTestCertificatePEM(t *testing.T, key interface{}, pub interface{}) []byte {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "webutils"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, key)
+
+	return encodeTestPEM(t, "CERTIFICATE", der, err)
+}
+
+func Test_ParseRSAPrivateKeyPEM(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(testPrivateKey)
+	pkcs8PEM := encodeTestPEM(t, "PRIVATE KEY", pkcs8, err)
+
+	ecPKCS8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	ecPEM := encodeTestPEM(t, "PRIVATE KEY", ecPKCS8, err)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"pkcs1", encodeTestPEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(testPrivateKey), nil), nil},
+		{"pkcs8", pkcs8PEM, nil},
+		{"base64 pkcs8", []byte(base64.StdEncoding.EncodeToString(pkcs8PEM)), nil},
+		{"ec pkcs8", ecPEM, ErrKeyNotRSA},
+		{
+			"public key",
+			encodeTestPEM(t, "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&testPrivateKey.PublicKey), nil),
+			ErrUnsupportedPEMType,
+		},
+		{"empty", nil, ErrNoPEMBlock},
+		{"not pem", []byte("-----BEGIN nonsense"), ErrNoPEMBlock},
+		{"invalid base64", []byte("not base64!"), ErrNoPEMBlock},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseRSAPrivateKeyPEM(tt.data)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.True(t, testPrivateKey.Equal(key))
+		})
+	}
+}
+
+func Test_ParseRSAPublicKeyPEM(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	pkix, err := x509.MarshalPKIXPublicKey(&testPrivateKey.PublicKey)
+	pkixPEM := encodeTestPEM(t, "PUBLIC KEY", pkix, err)
+
+	ecPKIX, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	ecPEM := encodeTestPEM(t, "PUBLIC KEY", ecPKIX, err)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"pkcs1", encodeTestPEM(t, "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&testPrivateKey.PublicKey), nil), nil},
+		{"pkix", pkixPEM, nil},
+		{"base64 pkix", []byte(base64.StdEncoding.EncodeToString(pkixPEM)), nil},
+		{"certificate", newTestCertificatePEM(t, testPrivateKey, &testPrivateKey.PublicKey), nil},
+		{"ec pkix", ecPEM, ErrKeyNotRSA},
+		{"ec certificate", newTestCertificatePEM(t, ecKey, &ecKey.PublicKey), ErrKeyNotRSA},
+		{
+			"private key",
+			encodeTestPEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(testPrivateKey), nil),
+			ErrUnsupportedPEMType,
+		},
+		{"empty", []byte("  \n"), ErrNoPEMBlock},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseRSAPublicKeyPEM(tt.data)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.True(t, testPrivateKey.PublicKey.Equal(key))
+		})
+	}
+}
+
+func Test_LoadRSAKeyEnv(t *testing.T) {
+	privatePEM := encodeTestPEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(testPrivateKey), nil)
+	publicPEM := encodeTestPEM(t, "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&testPrivateKey.PublicKey), nil)
+
+	// PEM with escaped newlines, as often found in .env files
+	os.Setenv("WEBUTILS_TEST_PRIVATE_KEY", strings.ReplaceAll(string(privatePEM), "\n", `\n`))
+	defer os.Unsetenv("WEBUTILS_TEST_PRIVATE_KEY")
+
+	os.Setenv("WEBUTILS_TEST_PUBLIC_KEY", base64.StdEncoding.EncodeToString(publicPEM))
+	defer os.Unsetenv("WEBUTILS_TEST_PUBLIC_KEY")
+
+	privateKey, err := LoadRSAPrivateKeyEnv("WEBUTILS_TEST_PRIVATE_KEY")
+	assert.Nil(t, err)
+	assert.True(t, testPrivateKey.Equal(privateKey))
+
+	publicKey, err := LoadRSAPublicKeyEnv("WEBUTILS_TEST_PUBLIC_KEY")
+	assert.Nil(t, err)
+	assert.True(t, testPrivateKey.PublicKey.Equal(publicKey))
+
+	_, err = LoadRSAPublicKeyEnv("WEBUTILS_TEST_UNSET_KEY")
+	assert.True(t, errors.Is(err, ErrKeyEnvNotSet))
+
+	_, err = LoadRSAPrivateKeyEnv("WEBUTILS_TEST_PUBLIC_KEY")
+	assert.True(t, errors.Is(err, ErrUnsupportedPEMType))
+}
+
+// lockedBuffer is a bytes.Buffer safe to read while a watcher logs to it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func Test_RSAKeyFileWatchers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webutils-keys")
+	assert.Nil(t, err)
+
+	defer os.RemoveAll(dir)
+
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	privatePath := filepath.Join(dir, "private.pem")
+	publicPath := filepath.Join(dir, "public.pem")
+
+	writeKeys := func(key *rsa.PrivateKey) {
+		pkix, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		assert.Nil(t, ioutil.WriteFile(publicPath, encodeTestPEM(t, "PUBLIC KEY", pkix, err), 0600))
+		assert.Nil(t, ioutil.WriteFile(
+			privatePath,
+			encodeTestPEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), nil),
+			0600,
+		))
+	}
+
+	writeKeys(testPrivateKey)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	_, err = NewRSAPublicKeyFileWatcher(ctx, RSAKeyFileWatcherOpts{})
+	assert.Equal(t, ErrNoKeyFilePath, err)
+
+	_, err = NewRSAPublicKeyFileWatcher(ctx, RSAKeyFileWatcherOpts{Path: filepath.Join(dir, "missing.pem")})
+	assert.NotNil(t, err)
+
+	publicWatcher, err := NewRSAPublicKeyFileWatcher(ctx, RSAKeyFileWatcherOpts{
+		Path:     publicPath,
+		Interval: 10 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+
+	privateWatcher, err := NewRSAPrivateKeyFileWatcher(ctx, RSAKeyFileWatcherOpts{
+		Path:     privatePath,
+		Interval: 10 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+
+	// a startup ctx ending doesn't stop the watching
+	cancel()
+
+	pk, err := publicWatcher.PublicKey(nil)
+	assert.Nil(t, err)
+	assert.True(t, testPrivateKey.PublicKey.Equal(pk))
+	assert.True(t, testPrivateKey.Equal(privateWatcher.Key()))
+
+	// a broken rotation is logged and keeps the current key
+	logged := &lockedBuffer{}
+	out := logger.Out
+	logger.SetOutput(logged)
+
+	assert.Nil(t, ioutil.WriteFile(publicPath, []byte("garbage"), 0600))
+	assert.Eventually(t, func() bool {
+		return strings.Contains(logged.String(), "parse("+publicPath+")")
+	}, time.Second, 10*time.Millisecond)
+	assert.True(t, testPrivateKey.PublicKey.Equal(publicWatcher.Key()))
+
+	logger.SetOutput(out)
+
+	writeKeys(rotated)
+
+	assert.Eventually(t, func() bool {
+		return rotated.PublicKey.Equal(publicWatcher.Key()) && rotated.Equal(privateWatcher.Key())
+	}, time.Second, 10*time.Millisecond)
+
+	publicWatcher.Close()
+	publicWatcher.Close()
+	privateWatcher.Close()
+
+	// closed watchers keep their current key
+	writeKeys(testPrivateKey)
+	assert.True(t, rotated.Equal(privateWatcher.Key()))
+}
@@ -0,0 +1,244 @@
+package webutils
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyberhorsey/errors"
+	jwt "github.com/golang-jwt/jwt/v4"
+	echo "github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	oidcDiscoveryPath             = "/.well-known/openid-configuration"
+	defaultOIDCRefreshInterval    = time.Hour
+	defaultOIDCSigningAlgorithm   = "RS256"
+	oidcDiscoveryRefreshTimeout   = 30 * time.Second
+	oidcDiscoveryRetryMinInterval = time.Minute
+)
+
+// supportedOIDCSigningMethods are the asymmetric algorithms we verify tokens with. Symmetric
+// algorithms are never accepted from a discovery document.
+var supportedOIDCSigningMethods = map[string]bool{
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+}
+
+// OIDCProviderOpts contains the options for NewOIDCProvider
+type OIDCProviderOpts struct {
+	// IssuerURL is the identity provider's issuer, ie: https://login.example.com/realms/main
+	IssuerURL string
+	// HTTPClient is used for discovery and key set requests. Defaults to a client with a 10 second
+	// timeout.
+	HTTPClient *http.Client
+	// RefreshInterval is how often the discovery document and key set are refetched. Defaults to
+	// one hour.
+	RefreshInterval time.Duration
+}
+
+// oidcDiscoveryDocument is the subset of the OpenID Connect discovery metadata we use
+type oidcDiscoveryDocument struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// OIDCProvider holds the token validation configuration of an OpenID Connect identity provider,
+// discovered from its issuer URL and periodically refreshed.
+type OIDCProvider struct {
+	issuer     string
+	client     *http.Client
+	minRefresh time.Duration
+
+	mu             sync.RWMutex
+	signingMethods []string
+	jwks           *JWKSKeyResolver
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewOIDCProvider fetches the provider's discovery document and key set, returning an error if
+// either is unusable. ctx only bounds these initial fetches; the configuration is then refreshed
+// every RefreshInterval until Close is called.
+func NewOIDCProvider(ctx context.Context, opts OIDCProviderOpts) (*OIDCProvider, error) {
+	if opts.IssuerURL == "" {
+		return nil, ErrNoOIDCIssuerURL
+	}
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = defaultOIDCRefreshInterval
+	}
+
+	p := &OIDCProvider{
+		issuer:     opts.IssuerURL,
+		client:     opts.HTTPClient,
+		minRefresh: oidcDiscoveryRetryMinInterval,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	if opts.RefreshInterval < p.minRefresh {
+		p.minRefresh = opts.RefreshInterval
+	}
+
+	if err := p.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	// a startup timeout on ctx must not end the refreshes keys are rotated by
+	go p.refreshEvery(Detach(ctx), opts.RefreshInterval)
+
+	return p, nil
+}
+
+// Refresh refetches the discovery document and key set, keeping the current configuration if
+// either is unusable.
+func (p *OIDCProvider) Refresh(ctx context.Context) error {
+	doc := &oidcDiscoveryDocument{}
+	if err := getJSON(ctx, p.client, strings.TrimSuffix(p.issuer, "/")+oidcDiscoveryPath, doc); err != nil {
+		return errors.Wrap(err, "getJSON(oidcDiscoveryPath)")
+	}
+
+	// the discovery document must be for the issuer we were configured with
+	if doc.Issuer != p.issuer {
+		return ErrOIDCIssuerMismatch
+	}
+
+	if doc.JWKSURI == "" {
+		return ErrNoJWKSURL
+	}
+
+	algs := doc.IDTokenSigningAlgValuesSupported
+	if len(algs) == 0 {
+		algs = []string{defaultOIDCSigningAlgorithm}
+	}
+
+	signingMethods := make([]string, 0)
+
+	for _, alg := range algs {
+		if supportedOIDCSigningMethods[alg] {
+			signingMethods = append(signingMethods, alg)
+		}
+	}
+
+	if len(signingMethods) == 0 {
+		return ErrNoOIDCSigningMethods
+	}
+
+	jwks, err := NewJWKSKeyResolver(ctx, JWKSKeyResolverOpts{
+		URL:                doc.JWKSURI,
+		HTTPClient:         p.client,
+		MinRefreshInterval: p.minRefresh,
+	})
+	if err != nil {
+		return errors.Wrap(err, "NewJWKSKeyResolver")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.signingMethods = signingMethods
+	p.jwks = jwks
+
+	return nil
+}
+
+func (p *OIDCProvider) refreshEvery(ctx context.Context, interval time.Duration) {
+	defer close(p.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			refreshCtx, cancel := context.WithTimeout(ctx, oidcDiscoveryRefreshTimeout)
+			if err := p.Refresh(refreshCtx); err != nil {
+				logger.WithFields(logrus.Fields{"issuer": p.issuer}).
+					Error(errors.Wrap(err, "p.Refresh"))
+			}
+			cancel()
+		}
+	}
+}
+
+// Close stops refreshing the configuration, returning once an in-flight refresh has finished
+func (p *OIDCProvider) Close() {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+	})
+
+	<-p.done
+}
+
+// Issuer returns the provider's issuer
+func (p *OIDCProvider) Issuer() string {
+	return p.issuer
+}
+
+// SigningMethods returns the signing algorithms accepted from the provider
+func (p *OIDCProvider) SigningMethods() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return append([]string(nil), p.signingMethods...)
+}
+
+// KeyFunc is a jwt.Keyfunc accepting the provider's current signing algorithms and resolving
+// keys from its current key set.
+func (p *OIDCProvider) KeyFunc(token *jwt.Token) (interface{}, error) {
+	p.mu.RLock()
+	signingMethods := p.signingMethods
+	jwks := p.jwks
+	p.mu.RUnlock()
+
+	alg := token.Method.Alg()
+
+	for _, m := range signingMethods {
+		if m == alg {
+			return jwks.KeyFunc(token)
+		}
+	}
+
+	return nil, ErrInvalidSigningMethod
+}
+
+// ConfigureOIDCJWTMiddleware configures JWT middleware validating tokens issued by the OpenID
+// Connect provider at providerOpts.IssuerURL. The issuer, key function and acceptance of untyped
+// tokens are set from the provider; the remaining opts are used as with ConfigureJWTMiddleware.
+// ctx bounds the initial discovery; the provider is refreshed for the life of the process.
+// opts.Audience is required, as tokens the provider issued to its other clients are otherwise
+// accepted.
+func ConfigureOIDCJWTMiddleware(
+	ctx context.Context,
+	providerOpts OIDCProviderOpts,
+	opts JWTMiddlewareOpts,
+) (echo.MiddlewareFunc, error) {
+	if opts.Audience == "" {
+		return nil, ErrNoOIDCAudience
+	}
+
+	provider, err := NewOIDCProvider(ctx, providerOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewOIDCProvider")
+	}
+
+	opts.Issuer = provider.Issuer()
+	opts.KeyFunc = provider.KeyFunc
+	opts.AllowUntypedTokens = true
+
+	return ConfigureJWTMiddleware(opts)
+}
@@ -0,0 +1,202 @@
+package webutils
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestOIDCServer is an OpenID Connect provider stand-in serving a discovery document and key
+// set for testPrivateKey. mutate can alter the discovery document served.
+func newTestOIDCServer(mutate func(doc map[string]interface{})) *httptest.Server {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+
+	mux.HandleFunc(oidcDiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		doc := map[string]interface{}{
+			"issuer":                                srv.URL,
+			"jwks_uri":                              srv.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256", "HS256"},
+		}
+
+		if mutate != nil {
+			mutate(doc)
+		}
+
+		_ = json.NewEncoder(w).Encode(doc)
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(JWKSet{Keys: []JWK{newTestRSAJWK("k1", &testPrivateKey.PublicKey)}})
+	})
+
+	return srv
+}
+
+func newTestOIDCJWT(t *testing.T, method jwt.SigningMethod, key interface{}, issuer, audience string) string {
+	token := jwt.NewWithClaims(method, jwt.StandardClaims{
+		Subject:   "oidc-user",
+		Issuer:    issuer,
+		Audience:  audience,
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "k1"
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+
+	return signed
+}
+
+func Test_NewOIDCProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(doc map[string]interface{})
+		wantErr error
+		wantAlg []string
+	}{
+		{"valid", nil, nil, []string{"RS256"}},
+		{"issuer mismatch", func(doc map[string]interface{}) {
+			doc["issuer"] = "https://evil.example.com"
+		}, ErrOIDCIssuerMismatch, nil},
+		{"no jwks uri", func(doc map[string]interface{}) { delete(doc, "jwks_uri") }, ErrNoJWKSURL, nil},
+		{"only symmetric algs", func(doc map[string]interface{}) {
+			doc["id_token_signing_alg_values_supported"] = []string{"HS256"}
+		}, ErrNoOIDCSigningMethods, nil},
+		{"default alg", func(doc map[string]interface{}) {
+			delete(doc, "id_token_signing_alg_values_supported")
+		}, nil, []string{"RS256"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestOIDCServer(tt.mutate)
+			defer srv.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			p, err := NewOIDCProvider(ctx, OIDCProviderOpts{IssuerURL: srv.URL})
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr, err)
+				return
+			}
+
+			assert.Nil(t, err)
+
+			defer p.Close()
+
+			assert.Equal(t, srv.URL, p.Issuer())
+			assert.Equal(t, tt.wantAlg, p.SigningMethods())
+		})
+	}
+
+	_, err := NewOIDCProvider(context.Background(), OIDCProviderOpts{})
+	assert.Equal(t, ErrNoOIDCIssuerURL, err)
+}
+
+func Test_OIDCProvider_Refresh(t *testing.T) {
+	var discoveries int32
+
+	srv := newTestOIDCServer(func(doc map[string]interface{}) {
+		atomic.AddInt32(&discoveries, 1)
+	})
+	defer srv.Close()
+
+	// a startup timeout doesn't stop the refreshes
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+
+	p, err := NewOIDCProvider(ctx, OIDCProviderOpts{IssuerURL: srv.URL, RefreshInterval: 10 * time.Millisecond})
+	assert.Nil(t, err)
+
+	cancel()
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&discoveries) >= 3
+	}, time.Second, 5*time.Millisecond)
+
+	p.Close()
+	p.Close()
+
+	// Close waits for the refreshing goroutine, so no more refreshes can happen
+	select {
+	case <-p.done:
+	default:
+		t.Error("refreshes still running after Close")
+	}
+}
+
+func Test_ConfigureOIDCJWTMiddleware(t *testing.T) {
+	srv := newTestOIDCServer(nil)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := ConfigureOIDCJWTMiddleware(ctx, OIDCProviderOpts{IssuerURL: srv.URL}, JWTMiddlewareOpts{})
+	assert.Equal(t, ErrNoOIDCAudience, err)
+
+	opts := JWTMiddlewareOpts{Audience: "api"}
+
+	_, err = ConfigureOIDCJWTMiddleware(ctx, OIDCProviderOpts{IssuerURL: srv.URL + "/other"}, opts)
+	assert.NotNil(t, err)
+
+	mw, err := ConfigureOIDCJWTMiddleware(ctx, OIDCProviderOpts{IssuerURL: srv.URL}, opts)
+	assert.Nil(t, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	rs256, iss, evil := jwt.SigningMethodRS256, srv.URL, "https://evil.example.com"
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"valid", newTestOIDCJWT(t, rs256, testPrivateKey, iss, "api"), http.StatusNoContent},
+		{"other client's token", newTestOIDCJWT(t, rs256, testPrivateKey, iss, "other"), http.StatusUnauthorized},
+		{"wrong issuer", newTestOIDCJWT(t, rs256, testPrivateKey, evil, "api"), http.StatusUnauthorized},
+		{"unadvertised alg", newTestOIDCJWT(t, jwt.SigningMethodRS512, testPrivateKey, iss, "api"), http.StatusUnauthorized},
+		{"wrong key", newTestOIDCJWT(t, jwt.SigningMethodES256, ecKey, iss, "api"), http.StatusUnauthorized},
+		{"typed refresh token", newTestJWT(t, newTestClaims(JWTRefresh, time.Hour)), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims *Claims
+
+			e := echo.New()
+			e.Use(mw)
+			e.GET("/protected", func(c echo.Context) error {
+				claims, _ = GetJWTClaimsFromContext(c.Request().Context())
+				return c.NoContent(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+tt.token)
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+
+			if tt.wantStatus == http.StatusNoContent {
+				assert.Equal(t, "oidc-user", claims.Subject)
+			}
+		})
+	}
+}
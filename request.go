@@ -2,6 +2,8 @@ package webutils
 
 import (
 	"context"
+	"net/http"
+	"regexp"
 	"strings"
 
 	"github.com/google/uuid"
@@ -15,6 +17,7 @@ const RequestIDHeader = "x-request-id"
 
 const pidKey ctxKey = ctxKey(4200)
 const ridKey ctxKey = ctxKey(4201)
+const correlationKey ctxKey = ctxKey(4204)
 
 // NewContext creates a context with provenance id
 func NewContext(ctx context.Context, provenanceID string, requestID string) context.Context {
@@ -36,17 +39,97 @@ func RequestIDFromContext(ctx context.Context) (string, bool) {
 	return rid, ok
 }
 
+// Correlation id decisions, recorded in the Logger entry of each request
+const (
+	correlationIDAccepted  = "accepted"
+	correlationIDGenerated = "generated"
+	correlationIDInvalid   = "replacedInvalid"
+	correlationIDUntrusted = "replacedUntrusted"
+)
+
+const defaultMaxCorrelationIDLength = 128
+
+// defaultCorrelationIDPattern allows the characters of uuids, trace ids and the ids common
+// gateways assign, and nothing that could forge log or header content
+var defaultCorrelationIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+// correlationDecisions records how the provenance and request ids of a request were chosen
+type correlationDecisions struct {
+	ProvenanceID string
+	RequestID    string
+}
+
+func correlationDecisionsFromContext(ctx context.Context) (correlationDecisions, bool) {
+	d, ok := ctx.Value(correlationKey).(correlationDecisions)
+	return d, ok
+}
+
+// ProvenanceIDMiddlewareOpts contains the options for ConfigureProvenanceIDMiddleware
+type ProvenanceIDMiddlewareOpts struct {
+	// MaxLength of inbound ids. Defaults to 128.
+	MaxLength int
+	// Pattern inbound ids must match. Defaults to letters, digits, ".", "_", ":" and "-".
+	Pattern *regexp.Regexp
+	// TrustedProxies whose inbound x-request-id is kept, ie: a gateway which assigns request ids.
+	// Request ids are always generated when nil.
+	TrustedProxies *TrustedProxies
+}
+
+// provenanceIDMiddleware sets the correlation ids and trace context of requests
+type provenanceIDMiddleware struct {
+	maxLength      int
+	pattern        *regexp.Regexp
+	trustedProxies *TrustedProxies
+}
+
+var defaultProvenanceIDMiddleware = ConfigureProvenanceIDMiddleware(ProvenanceIDMiddlewareOpts{})
+
+// ProvenanceIDMiddleware sets the provenance and request ids, and the W3C trace context, of the
+// request, with the default ConfigureProvenanceIDMiddleware options.
 func ProvenanceIDMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return defaultProvenanceIDMiddleware(next)
+}
+
+// ConfigureProvenanceIDMiddleware configures middleware setting the provenance and request ids,
+// and the W3C trace context, of requests. The trace in a valid traceparent header is continued
+// with a new span, otherwise a new trace is started; when there is no valid provenance id header,
+// the trace id is used as provenance id so both correlate. Request ids are generated per request
+// unless a valid one is set by a trusted proxy. The request's root span is started, and ended
+// once the request is handled.
+func ConfigureProvenanceIDMiddleware(opts ProvenanceIDMiddlewareOpts) echo.MiddlewareFunc {
+	mw := &provenanceIDMiddleware{
+		maxLength:      opts.MaxLength,
+		pattern:        opts.Pattern,
+		trustedProxies: opts.TrustedProxies,
+	}
+
+	if mw.maxLength <= 0 {
+		mw.maxLength = defaultMaxCorrelationIDLength
+	}
+
+	if mw.pattern == nil {
+		mw.pattern = defaultCorrelationIDPattern
+	}
+
+	return mw.Handler
+}
+
+func (mw *provenanceIDMiddleware) Handler(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		provenanceID := c.Request().Header.Get(ProvenanceIDHeader)
-		if provenanceID == "" {
-			provenanceID = strings.ReplaceAll(uuid.New().String(), "-", "")
-			c.Request().Header.Set(ProvenanceIDHeader, provenanceID)
-		}
+		tc := newTraceContext(c.Request().Header.Get(TraceparentHeader), c.Request().Header.Get(TracestateHeader))
 
-		requestID := strings.ReplaceAll(uuid.New().String(), "-", "")
+		provenanceID, requestID, decisions := mw.correlationIDs(c.Request(), tc)
+		c.Request().Header.Set(ProvenanceIDHeader, provenanceID)
 
 		ctx := NewContext(c.Request().Context(), provenanceID, requestID)
+		ctx = context.WithValue(ctx, correlationKey, decisions)
+		ctx, span := startSpan(ctx, tc, c.Request().Method+" "+c.Path(), SpanKindServer)
+
+		defer span.End()
+
+		span.SetAttribute("http.method", c.Request().Method)
+		span.SetAttribute("http.route", c.Path())
+		span.SetAttribute("http.target", c.Request().RequestURI)
 
 		c.Response().Header().Add(ProvenanceIDHeader, provenanceID)
 
@@ -56,6 +139,70 @@ func ProvenanceIDMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 
 		c.SetRequest(r)
 
-		return next(c)
+		// errors rendered by LogAndRenderErrors are already recorded
+		err := next(c)
+		if _, rendered := err.(ErrorResponse); err != nil && !rendered {
+			span.RecordError(err)
+		}
+
+		span.SetAttribute("http.status_code", responseStatus(c, err))
+
+		return err
+	}
+}
+
+// responseStatus returns the status of the response to a handled request. Errors returned without
+// a response are rendered later by echo's HTTPErrorHandler, as their echo.HTTPError code or a 500.
+func responseStatus(c echo.Context, err error) int {
+	if err == nil || c.Response().Committed {
+		return c.Response().Status
+	}
+
+	if he, ok := err.(*echo.HTTPError); ok {
+		return he.Code
 	}
+
+	return http.StatusInternalServerError
+}
+
+// correlationIDs chooses the provenance and request ids of r
+func (mw *provenanceIDMiddleware) correlationIDs(
+	r *http.Request,
+	tc TraceContext,
+) (provenanceID, requestID string, decisions correlationDecisions) {
+	provenanceID = r.Header.Get(ProvenanceIDHeader)
+
+	switch {
+	case provenanceID == "":
+		provenanceID, decisions.ProvenanceID = tc.TraceID, correlationIDGenerated
+	case !mw.valid(provenanceID):
+		provenanceID, decisions.ProvenanceID = tc.TraceID, correlationIDInvalid
+	default:
+		decisions.ProvenanceID = correlationIDAccepted
+	}
+
+	requestID = r.Header.Get(RequestIDHeader)
+
+	switch {
+	case requestID == "":
+		decisions.RequestID = correlationIDGenerated
+	case mw.trustedProxies == nil || !mw.trustedProxies.trustsPeer(r):
+		decisions.RequestID = correlationIDUntrusted
+	case !mw.valid(requestID):
+		decisions.RequestID = correlationIDInvalid
+	default:
+		return provenanceID, requestID, correlationDecisions{decisions.ProvenanceID, correlationIDAccepted}
+	}
+
+	return provenanceID, newCorrelationID(), decisions
+}
+
+// valid indicates whether an inbound id may be used
+func (mw *provenanceIDMiddleware) valid(id string) bool {
+	return len(id) <= mw.maxLength && mw.pattern.MatchString(id)
+}
+
+// newCorrelationID generates a provenance or request id
+func newCorrelationID() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	echo "github.com/labstack/echo/v4"
@@ -38,3 +39,79 @@ func Test_ProvenanceIDMiddleware(t *testing.T) {
 	assert.NotEqual(t, "", rec.Header().Get(ProvenanceIDHeader))
 	assert.NotEqual(t, "", rec.Body.String())
 }
+
+func Test_ConfigureProvenanceIDMiddleware(t *testing.T) {
+	proxies, err := NewTrustedProxies("10.0.0.0/8")
+	assert.Nil(t, err)
+
+	mw := ConfigureProvenanceIDMiddleware(ProvenanceIDMiddlewareOpts{MaxLength: 40, TrustedProxies: proxies})
+
+	tests := []struct {
+		name                 string
+		mw                   echo.MiddlewareFunc
+		remoteAddr           string
+		provenanceID         string
+		requestID            string
+		wantProvenanceID     string
+		wantRequestID        string
+		wantProvenanceResult string
+		wantRequestResult    string
+	}{
+		{"generated", mw, "10.0.0.1:1234", "", "", "", "", correlationIDGenerated, correlationIDGenerated},
+		{"accepted", mw, "10.0.0.1:1234", "pid-1", "rid-1", "pid-1", "rid-1", correlationIDAccepted,
+			correlationIDAccepted},
+		{"untrusted request id", mw, "203.0.113.7:1234", "pid-1", "rid-1", "pid-1", "", correlationIDAccepted,
+			correlationIDUntrusted},
+		{"invalid characters", mw, "10.0.0.1:1234", "pid\n{\"forged\":1}", "rid 1", "", "", correlationIDInvalid,
+			correlationIDInvalid},
+		{"too long", mw, "10.0.0.1:1234", strings.Repeat("a", 41), "", "", "", correlationIDInvalid,
+			correlationIDGenerated},
+		{"request ids untrusted by default", ProvenanceIDMiddleware, "10.0.0.1:1234", "pid-1", "rid-1", "pid-1", "",
+			correlationIDAccepted, correlationIDUntrusted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctx context.Context
+
+			h := tt.mw(func(c echo.Context) error {
+				ctx = c.Request().Context()
+				return c.NoContent(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set(ProvenanceIDHeader, tt.provenanceID)
+			req.Header.Set(RequestIDHeader, tt.requestID)
+
+			rec := httptest.NewRecorder()
+			assert.Nil(t, h(echo.New().NewContext(req, rec)))
+
+			pid, _ := ProvenanceIDFromContext(ctx)
+			rid, _ := RequestIDFromContext(ctx)
+			traceID, _ := TraceIDFromContext(ctx)
+
+			if tt.wantProvenanceID == "" {
+				// replaced by the trace id
+				assert.Equal(t, traceID, pid)
+			} else {
+				assert.Equal(t, tt.wantProvenanceID, pid)
+			}
+
+			if tt.wantRequestID == "" {
+				assert.Len(t, rid, 32)
+				assert.NotEqual(t, tt.requestID, rid)
+			} else {
+				assert.Equal(t, tt.wantRequestID, rid)
+			}
+
+			assert.Equal(t, pid, rec.Header().Get(ProvenanceIDHeader))
+			assert.Equal(t, rid, rec.Header().Get(RequestIDHeader))
+
+			decisions, ok := correlationDecisionsFromContext(ctx)
+			assert.True(t, ok)
+			assert.Equal(t, tt.wantProvenanceResult, decisions.ProvenanceID)
+			assert.Equal(t, tt.wantRequestResult, decisions.RequestID)
+		})
+	}
+}
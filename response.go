@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/cyberhorsey/errors"
 	echo "github.com/labstack/echo/v4"
 )
 
@@ -32,10 +33,10 @@ func LogNotifyAndRenderUnexpectedError(
 	if jsonErr != nil {
 		c.Logger().Errorf("webutils.LogAndRenderError encountered unexpected c.JSON error: %v", jsonErr)
 	}
-	// notify?
+	// notify? the notification keeps the request's correlation ids, but not its cancellation
 	if nSvc != nil {
-		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		Go(c.Request().Context(), func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 			defer cancel()
 
 			if err := nSvc.Notify(
@@ -47,9 +48,11 @@ func LogNotifyAndRenderUnexpectedError(
 					Error:    err,
 				},
 			); err != nil {
-				c.Logger().Errorf("failed to publish notification error: \"%+v\"", err)
+				return errors.Wrap(err, "failed to publish notification error")
 			}
-		}()
+
+			return nil
+		})
 	}
 	// return the original error which will be logged with Echo's access log
 	return err
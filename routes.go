@@ -0,0 +1,146 @@
+package webutils
+
+import (
+	"strings"
+
+	"github.com/cyberhorsey/errors"
+	echo "github.com/labstack/echo/v4"
+)
+
+const (
+	routeAnyMethod = "*"
+	routeWildcard  = "*"
+	routeParam     = ":"
+)
+
+// routePattern is a compiled route pattern, with parameter names dropped
+type routePattern struct {
+	method   string
+	segments []string
+}
+
+// RouteMatcher matches requests against method and path patterns, ie: "GET /docs/*" or
+// "POST /webhooks/:provider". Paths use echo route syntax: a ":name" segment matches a parameter
+// or wildcard segment of the route, never a literal one, so "GET /users/:id" doesn't match a
+// "/users/me" route. A "*" segment matches any single segment, except when last where it matches
+// the remainder of the path. A "*" method matches any method.
+//
+// Patterns are matched against the route template the request was routed to, rather than its
+// URL, so "/webhooks/:provider" matches a route registered as "/webhooks/:name" and no URL can
+// make a request for another route match.
+type RouteMatcher struct {
+	patterns []routePattern
+}
+
+// NewRouteMatcher compiles the patterns into a RouteMatcher
+func NewRouteMatcher(patterns ...string) (*RouteMatcher, error) {
+	m := &RouteMatcher{}
+
+	for _, pattern := range patterns {
+		compiled, err := compileRoutePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		m.patterns = append(m.patterns, compiled)
+	}
+
+	return m, nil
+}
+
+func compileRoutePattern(pattern string) (routePattern, error) {
+	fields := strings.Fields(pattern)
+	if len(fields) != 2 || !strings.HasPrefix(fields[1], "/") {
+		return routePattern{}, errors.Wrapf(ErrInvalidRoutePattern, "%q", pattern)
+	}
+
+	segments := routeSegments(fields[1])
+
+	for _, s := range segments {
+		// no empty segments or partial wildcards, which echo routes never contain
+		if s == "" || (s != routeWildcard && strings.Contains(s, routeWildcard)) {
+			return routePattern{}, errors.Wrapf(ErrInvalidRoutePattern, "%q", pattern)
+		}
+	}
+
+	return routePattern{
+		method:   strings.ToUpper(fields[0]),
+		segments: segments,
+	}, nil
+}
+
+// routeSegments splits a path into segments, ignoring leading and trailing slashes and
+// normalizing parameters to ":"
+func routeSegments(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+
+	segments := strings.Split(path, "/")
+
+	for i, s := range segments {
+		if strings.HasPrefix(s, routeParam) {
+			segments[i] = routeParam
+		}
+	}
+
+	return segments
+}
+
+// Match indicates whether the route the request was routed to matches any of the patterns
+func (m *RouteMatcher) Match(c echo.Context) bool {
+	method := c.Request().Method
+	segments := routeSegments(c.Path())
+
+	for _, p := range m.patterns {
+		if (p.method == routeAnyMethod || p.method == method) && p.match(segments) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p routePattern) match(segments []string) bool {
+	for i, s := range p.segments {
+		// a trailing wildcard matches the remainder, which may be empty
+		if s == routeWildcard && i == len(p.segments)-1 {
+			return len(segments) >= i
+		}
+
+		if i >= len(segments) {
+			return false
+		}
+
+		if !matchRouteSegment(s, segments[i]) {
+			return false
+		}
+	}
+
+	return len(segments) == len(p.segments)
+}
+
+// hasParams indicates whether the pattern has route param segments
+func (p routePattern) hasParams() bool {
+	for _, s := range p.segments {
+		if s == routeParam {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchRouteSegment matches a pattern segment against a route template segment. A wildcard
+// matches any segment, a parameter only a parameter or wildcard, and a literal only itself.
+func matchRouteSegment(pattern, segment string) bool {
+	switch pattern {
+	case routeWildcard:
+		return true
+	case routeParam:
+		return segment == routeParam || segment == routeWildcard
+	default:
+		return pattern == segment
+	}
+}
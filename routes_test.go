@@ -0,0 +1,107 @@
+package webutils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewRouteMatcher_Invalid(t *testing.T) {
+	for _, pattern := range []string{"/docs", "GET docs", "GET /docs /more", "GET /docs//x", "GET /docs*"} {
+		_, err := NewRouteMatcher(pattern)
+		assert.True(t, errors.Is(err, ErrInvalidRoutePattern), pattern)
+	}
+}
+
+func Test_RouteMatcher_Match(t *testing.T) {
+	m, err := NewRouteMatcher("GET /docs/*", "post /webhooks/:provider", "* /status", "GET /users/*/avatar")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		method string
+		route  string
+		want   bool
+	}{
+		{http.MethodGet, "/docs/*", true},
+		{http.MethodGet, "/docs/api/v1", true},
+		{http.MethodPost, "/docs/*", false},
+		{http.MethodPost, "/webhooks/:name", true},
+		{http.MethodPost, "/webhooks/github", false},
+		{http.MethodPost, "/webhooks/*", true},
+		{http.MethodPost, "/webhooks/:name/replay", false},
+		{http.MethodDelete, "/status", true},
+		{http.MethodGet, "/status/", true},
+		{http.MethodGet, "/users/:id/avatar", true},
+		{http.MethodGet, "/users/:id", false},
+		{http.MethodGet, "/", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.route, func(t *testing.T) {
+			c := echo.New().NewContext(httptest.NewRequest(tt.method, "/", nil), httptest.NewRecorder())
+			c.SetPath(tt.route)
+
+			assert.Equal(t, tt.want, m.Match(c))
+		})
+	}
+}
+
+func Test_JWTMiddleware_PublicRoutes(t *testing.T) {
+	_, err := ConfigureJWTMiddleware(JWTMiddlewareOpts{PublicKey: testPublicKeyFunc, PublicRoutes: []string{"/docs"}})
+	assert.True(t, errors.Is(err, ErrInvalidRoutePattern))
+
+	mw, err := ConfigureJWTMiddleware(JWTMiddlewareOpts{
+		PublicKey:    testPublicKeyFunc,
+		PublicRoutes: []string{"GET /docs/*", "POST /webhooks/:provider"},
+	})
+	assert.Nil(t, err)
+
+	e := echo.New()
+	e.Use(mw)
+
+	handler := func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	e.GET("/docs/*", handler)
+	e.POST("/webhooks/:provider", handler)
+	e.GET("/webhooks/:provider", handler)
+	e.POST("/webhooks/internal", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/admin/users", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	tests := []struct {
+		method string
+		url    string
+		want   int
+	}{
+		{http.MethodGet, "/docs/index.html", http.StatusNoContent},
+		{http.MethodPost, "/webhooks/github", http.StatusNoContent},
+		{http.MethodGet, "/webhooks/github", http.StatusUnauthorized},
+		// a static route isn't made public by a parameter pattern
+		{http.MethodPost, "/webhooks/internal", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/users", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/users/", http.StatusUnauthorized},
+		// routed to the docs, not the admin handler
+		{http.MethodGet, "/docs/../admin/users", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.url, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.URL.Path = tt.url
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
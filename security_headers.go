@@ -0,0 +1,320 @@
+package webutils
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cyberhorsey/errors"
+	echo "github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderPermissionsPolicy       = "Permissions-Policy"
+	HeaderCrossOriginOpenerPolicy = "Cross-Origin-Opener-Policy"
+
+	// CSPNoncePlaceholder is replaced with the request's nonce in content security policies
+	CSPNoncePlaceholder = "{nonce}"
+
+	defaultHSTSMaxAge        = 2 * 365 * 24 * time.Hour
+	defaultPermissionsPolicy = "camera=(), microphone=(), geolocation=(), payment=(), usb=()"
+	defaultAPICSP            = "default-src 'none'; frame-ancestors 'none'"
+	defaultHTMLCSP           = "default-src 'self'; " +
+		"script-src 'self' 'nonce-" + CSPNoncePlaceholder + "' 'strict-dynamic'; " +
+		"style-src 'self' 'nonce-" + CSPNoncePlaceholder + "'; " +
+		"img-src 'self' data:; object-src 'none'; base-uri 'none'; form-action 'self'; frame-ancestors 'none'"
+
+	// cspNonceLength is the number of random bytes in a nonce, hex encoded into twice as many characters
+	cspNonceLength = 16
+	// maxCSPReportSize bounds the violation reports CSPReportHandler reads
+	maxCSPReportSize = 64 * 1024
+
+	// contextKeyCSPNonce is the echo.Context key of the request's CSP nonce
+	contextKeyCSPNonce = "csp-nonce"
+)
+
+// SecurityHeadersProfile selects the defaults of ConfigureSecurityHeadersMiddleware
+type SecurityHeadersProfile int
+
+const (
+	// SecurityHeadersAPI suits JSON APIs, which never render content: nothing may be loaded or
+	// framed, and no referrer is sent
+	SecurityHeadersAPI SecurityHeadersProfile = iota
+	// SecurityHeadersHTML suits rendered pages: only same-origin content and scripts and styles
+	// carrying the request's nonce may load, and windows are isolated from cross-origin openers
+	SecurityHeadersHTML
+)
+
+// SecurityHeadersOpts contains the options for ConfigureSecurityHeadersMiddleware. Empty options
+// take the Profile's defaults.
+type SecurityHeadersOpts struct {
+	Skipper func(c echo.Context) bool
+	Profile SecurityHeadersProfile
+	// HSTSMaxAge defaults to two years; negative values omit Strict-Transport-Security. It is only
+	// sent for https requests, as browsers ignore it otherwise.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// ReferrerPolicy defaults to "no-referrer" for APIs and "strict-origin-when-cross-origin" for HTML
+	ReferrerPolicy string
+	// PermissionsPolicy defaults to disabling the camera, microphone, geolocation, payment and usb
+	PermissionsPolicy string
+	// ContentSecurityPolicy defaults to the Profile's policy. CSPNoncePlaceholder is replaced with
+	// a random nonce per request, which templates embed from CSPNonceFromContext.
+	ContentSecurityPolicy string
+	// CSPReportOnly reports violations without enforcing the policy, for rolling out a new policy
+	CSPReportOnly bool
+	// CSPReportURI is where browsers send violation reports, ie: a route served by CSPReportHandler
+	CSPReportURI string
+}
+
+// securityHeadersMiddleware sets response security headers
+type securityHeadersMiddleware struct {
+	skipper   func(c echo.Context) bool
+	hsts      string
+	headers   map[string]string
+	cspHeader string
+	csp       string
+	nonce     bool
+}
+
+// ConfigureSecurityHeadersMiddleware configures middleware setting Strict-Transport-Security,
+// X-Content-Type-Options, X-Frame-Options, Referrer-Policy, Permissions-Policy and
+// Content-Security-Policy consistently, with defaults for opts.Profile.
+func ConfigureSecurityHeadersMiddleware(opts SecurityHeadersOpts) echo.MiddlewareFunc {
+	mw := &securityHeadersMiddleware{
+		skipper:   opts.Skipper,
+		cspHeader: echo.HeaderContentSecurityPolicy,
+		csp:       opts.ContentSecurityPolicy,
+		headers: map[string]string{
+			echo.HeaderXContentTypeOptions: "nosniff",
+			echo.HeaderXFrameOptions:       "DENY",
+			HeaderPermissionsPolicy:        opts.PermissionsPolicy,
+			echo.HeaderReferrerPolicy:      opts.ReferrerPolicy,
+		},
+	}
+
+	if mw.skipper == nil {
+		mw.skipper = func(c echo.Context) bool { return false }
+	}
+
+	if mw.headers[HeaderPermissionsPolicy] == "" {
+		mw.headers[HeaderPermissionsPolicy] = defaultPermissionsPolicy
+	}
+
+	switch opts.Profile {
+	case SecurityHeadersHTML:
+		mw.headers[HeaderCrossOriginOpenerPolicy] = "same-origin"
+
+		if mw.headers[echo.HeaderReferrerPolicy] == "" {
+			mw.headers[echo.HeaderReferrerPolicy] = "strict-origin-when-cross-origin"
+		}
+
+		if mw.csp == "" {
+			mw.csp = defaultHTMLCSP
+		}
+	default:
+		if mw.headers[echo.HeaderReferrerPolicy] == "" {
+			mw.headers[echo.HeaderReferrerPolicy] = "no-referrer"
+		}
+
+		if mw.csp == "" {
+			mw.csp = defaultAPICSP
+		}
+	}
+
+	if opts.CSPReportURI != "" {
+		mw.csp += "; report-uri " + opts.CSPReportURI
+	}
+
+	if opts.CSPReportOnly {
+		mw.cspHeader = echo.HeaderContentSecurityPolicyReportOnly
+	}
+
+	mw.nonce = strings.Contains(mw.csp, CSPNoncePlaceholder)
+
+	if opts.HSTSMaxAge == 0 {
+		opts.HSTSMaxAge = defaultHSTSMaxAge
+	}
+
+	if opts.HSTSMaxAge > 0 {
+		mw.hsts = "max-age=" + strconv.FormatInt(int64(opts.HSTSMaxAge.Seconds()), 10)
+
+		if opts.HSTSIncludeSubdomains {
+			mw.hsts += "; includeSubDomains"
+		}
+
+		if opts.HSTSPreload {
+			mw.hsts += "; preload"
+		}
+	}
+
+	return mw.Handler
+}
+
+func (mw *securityHeadersMiddleware) Handler(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if mw.skipper(c) {
+			return next(c)
+		}
+
+		header := c.Response().Header()
+
+		for name, value := range mw.headers {
+			header.Set(name, value)
+		}
+
+		if mw.hsts != "" && c.Scheme() == "https" {
+			header.Set(echo.HeaderStrictTransportSecurity, mw.hsts)
+		}
+
+		csp := mw.csp
+
+		if mw.nonce {
+			nonce, err := SecureRandomHex(cspNonceLength)
+			if err != nil {
+				return LogAndRenderUnexpectedError(c, errors.Wrap(err, "SecureRandomHex"))
+			}
+
+			c.Set(contextKeyCSPNonce, nonce)
+
+			csp = strings.ReplaceAll(csp, CSPNoncePlaceholder, nonce)
+		}
+
+		header.Set(mw.cspHeader, csp)
+
+		return next(c)
+	}
+}
+
+// CSPNonceFromContext returns the request's CSP nonce, for templates to set on inline script and
+// style elements, ie: <script nonce="{{ .Nonce }}">
+func CSPNonceFromContext(c echo.Context) string {
+	nonce, _ := c.Get(contextKeyCSPNonce).(string)
+	return nonce
+}
+
+// CSPNonceFromRequest is CSPNonceFromContext for net/http handlers behind the adapted middleware
+func CSPNonceFromRequest(r *http.Request) string {
+	c, ok := echoContextFromRequest(r)
+	if !ok {
+		return ""
+	}
+
+	return CSPNonceFromContext(c)
+}
+
+// CSPViolation is a content security policy violation reported by a browser
+type CSPViolation struct {
+	DocumentURI        string `json:"documentURI"`
+	Referrer           string `json:"referrer"`
+	BlockedURI         string `json:"blockedURI"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	OriginalPolicy     string `json:"originalPolicy"`
+	Disposition        string `json:"disposition"`
+	SourceFile         string `json:"sourceFile"`
+	LineNumber         int    `json:"lineNumber"`
+	ColumnNumber       int    `json:"columnNumber"`
+	StatusCode         int    `json:"statusCode"`
+}
+
+// legacyCSPViolation is the report-uri report format
+type legacyCSPViolation struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		Referrer           string `json:"referrer"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		OriginalPolicy     string `json:"original-policy"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+		StatusCode         int    `json:"status-code"`
+	} `json:"csp-report"`
+}
+
+// reportingAPIReport is a Reporting API report, of which CSP violations are one type
+type reportingAPIReport struct {
+	Type string       `json:"type"`
+	Body CSPViolation `json:"body"`
+}
+
+// CSPReportHandler receives content security policy violation reports, in either the report-uri
+// or the Reporting API format, and logs them
+func CSPReportHandler(c echo.Context) error {
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request().Body, maxCSPReportSize))
+	if err != nil {
+		return LogAndRenderErrors(c, http.StatusBadRequest, ErrInvalidCSPReport)
+	}
+
+	violations, err := parseCSPReport(body)
+	if err != nil {
+		return LogAndRenderErrors(c, http.StatusBadRequest, errors.WithCause(ErrInvalidCSPReport, err))
+	}
+
+	fields := logFields(c.Request().Context())
+
+	for _, v := range violations {
+		fields["documentUri"] = v.DocumentURI
+		fields["blockedUri"] = v.BlockedURI
+		fields["effectiveDirective"] = v.EffectiveDirective
+		fields["disposition"] = v.Disposition
+		fields["sourceFile"] = v.SourceFile
+		fields["lineNumber"] = v.LineNumber
+
+		logger.WithFields(fields).Warn("content security policy violation")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// parseCSPReport parses a report-uri report or a batch of Reporting API reports
+func parseCSPReport(body []byte) ([]CSPViolation, error) {
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		reports := []reportingAPIReport{}
+		if err := json.Unmarshal(body, &reports); err != nil {
+			return nil, errors.Wrap(err, "json.Unmarshal")
+		}
+
+		violations := []CSPViolation{}
+
+		for _, r := range reports {
+			if r.Type == "csp-violation" {
+				violations = append(violations, r.Body)
+			}
+		}
+
+		return violations, nil
+	}
+
+	legacy := legacyCSPViolation{}
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+
+	r := legacy.Report
+
+	directive := r.EffectiveDirective
+	if directive == "" {
+		directive = r.ViolatedDirective
+	}
+
+	return []CSPViolation{{
+		DocumentURI:        r.DocumentURI,
+		Referrer:           r.Referrer,
+		BlockedURI:         r.BlockedURI,
+		EffectiveDirective: directive,
+		OriginalPolicy:     r.OriginalPolicy,
+		Disposition:        r.Disposition,
+		SourceFile:         r.SourceFile,
+		LineNumber:         r.LineNumber,
+		ColumnNumber:       r.ColumnNumber,
+		StatusCode:         r.StatusCode,
+	}}, nil
+}
@@ -0,0 +1,194 @@
+package webutils
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveSecurityHeaders(opts SecurityHeadersOpts, https bool) (*httptest.ResponseRecorder, string) {
+	e := echo.New()
+	e.Use(ConfigureSecurityHeadersMiddleware(opts))
+
+	var nonce string
+
+	e.GET("/", func(c echo.Context) error {
+		nonce = CSPNonceFromContext(c)
+		return c.NoContent(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if https {
+		req.Header.Set(echo.HeaderXForwardedProto, "https")
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	return rec, nonce
+}
+
+func Test_ConfigureSecurityHeadersMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           SecurityHeadersOpts
+		https          bool
+		wantHeaders    map[string]string
+		wantCSPHeader  string
+		wantCSPContent string
+		wantNonce      bool
+	}{
+		{
+			"api",
+			SecurityHeadersOpts{},
+			true,
+			map[string]string{
+				echo.HeaderStrictTransportSecurity: "max-age=63072000",
+				echo.HeaderXContentTypeOptions:     "nosniff",
+				echo.HeaderXFrameOptions:           "DENY",
+				echo.HeaderReferrerPolicy:          "no-referrer",
+				HeaderPermissionsPolicy:            defaultPermissionsPolicy,
+				HeaderCrossOriginOpenerPolicy:      "",
+			},
+			echo.HeaderContentSecurityPolicy,
+			defaultAPICSP,
+			false,
+		},
+		{
+			"api over http",
+			SecurityHeadersOpts{},
+			false,
+			map[string]string{echo.HeaderStrictTransportSecurity: ""},
+			echo.HeaderContentSecurityPolicy,
+			defaultAPICSP,
+			false,
+		},
+		{
+			"html",
+			SecurityHeadersOpts{Profile: SecurityHeadersHTML, HSTSIncludeSubdomains: true, HSTSPreload: true},
+			true,
+			map[string]string{
+				echo.HeaderStrictTransportSecurity: "max-age=63072000; includeSubDomains; preload",
+				echo.HeaderReferrerPolicy:          "strict-origin-when-cross-origin",
+				HeaderCrossOriginOpenerPolicy:      "same-origin",
+			},
+			echo.HeaderContentSecurityPolicy,
+			"script-src 'self' 'nonce-",
+			true,
+		},
+		{
+			"report only",
+			SecurityHeadersOpts{
+				Profile:        SecurityHeadersHTML,
+				HSTSMaxAge:     -1,
+				ReferrerPolicy: "same-origin",
+				CSPReportOnly:  true,
+				CSPReportURI:   "/csp-reports",
+			},
+			true,
+			map[string]string{
+				echo.HeaderStrictTransportSecurity: "",
+				echo.HeaderReferrerPolicy:          "same-origin",
+				echo.HeaderContentSecurityPolicy:   "",
+			},
+			echo.HeaderContentSecurityPolicyReportOnly,
+			"; report-uri /csp-reports",
+			true,
+		},
+		{
+			"custom policy",
+			SecurityHeadersOpts{ContentSecurityPolicy: "default-src 'self'", HSTSMaxAge: time.Hour},
+			true,
+			map[string]string{echo.HeaderStrictTransportSecurity: "max-age=3600"},
+			echo.HeaderContentSecurityPolicy,
+			"default-src 'self'",
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, nonce := serveSecurityHeaders(tt.opts, tt.https)
+
+			for name, want := range tt.wantHeaders {
+				assert.Equal(t, want, rec.Header().Get(name), name)
+			}
+
+			csp := rec.Header().Get(tt.wantCSPHeader)
+			assert.Contains(t, csp, tt.wantCSPContent)
+			assert.NotContains(t, csp, CSPNoncePlaceholder)
+
+			if !tt.wantNonce {
+				assert.Equal(t, "", nonce)
+				return
+			}
+
+			assert.Len(t, nonce, cspNonceLength*2)
+			assert.Contains(t, csp, "'nonce-"+nonce+"'")
+
+			// nonces are unique per request
+			_, next := serveSecurityHeaders(tt.opts, tt.https)
+			assert.NotEqual(t, nonce, next)
+		})
+	}
+}
+
+func Test_CSPReportHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantLogged  []string
+		wantMissing string
+	}{
+		{
+			"report-uri",
+			`{"csp-report":{"document-uri":"https://example.com/page","blocked-uri":"https://evil.com/x.js",` +
+				`"violated-directive":"script-src-elem","disposition":"enforce"}}`,
+			http.StatusNoContent,
+			[]string{`"blockedUri":"https://evil.com/x.js"`, `"effectiveDirective":"script-src-elem"`},
+			"",
+		},
+		{
+			"reporting api",
+			`[{"type":"csp-violation","body":{"documentURI":"https://example.com/page","blockedURI":"inline",` +
+				`"effectiveDirective":"style-src-attr","disposition":"report"}},` +
+				`{"type":"deprecation","body":{"documentURI":"https://example.com/other"}}]`,
+			http.StatusNoContent,
+			[]string{`"blockedUri":"inline"`, `"disposition":"report"`},
+			"https://example.com/other",
+		},
+		{"invalid", `{"csp-report":`, http.StatusBadRequest, []string{"ERR_INVALID_CSP_REPORT"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := logger.Out
+			buf := &bytes.Buffer{}
+			logger.SetOutput(buf)
+
+			defer logger.SetOutput(out)
+
+			req := httptest.NewRequest(http.MethodPost, "/csp-reports", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, "application/csp-report")
+
+			rec := httptest.NewRecorder()
+			_ = CSPReportHandler(echo.New().NewContext(req, rec))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+
+			for _, want := range tt.wantLogged {
+				assert.Contains(t, buf.String()+rec.Body.String(), want)
+			}
+
+			if tt.wantMissing != "" {
+				assert.NotContains(t, buf.String(), tt.wantMissing)
+			}
+		})
+	}
+}
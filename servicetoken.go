@@ -0,0 +1,205 @@
+package webutils
+
+import (
+	"context"
+	"crypto/rsa"
+	"sync"
+	"time"
+
+	"github.com/cyberhorsey/errors"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	defaultServiceTokenLifetime      = 15 * time.Minute
+	defaultServiceTokenRefreshBefore = time.Minute
+)
+
+var _ credentials.PerRPCCredentials = (*ServiceTokenSource)(nil)
+
+// TokenSource supplies access tokens for outbound calls
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// ServiceTokenSourceOpts contains the options for NewServiceTokenSource
+type ServiceTokenSourceOpts struct {
+	// Identity is the calling service's name, used as the token subject and username
+	Identity string
+	// UserID is an optional user id for services which are represented as users
+	UserID   uint
+	Issuer   string
+	Audience string
+	Scope    string
+	// PrivateKey signs the tokens, as with CreateJWT
+	PrivateKey *rsa.PrivateKey
+	// Lifetime of each token. Defaults to 15 minutes.
+	Lifetime time.Duration
+	// RefreshBefore is how long before expiry a cached token is replaced. Defaults to one minute.
+	RefreshBefore time.Duration
+	// AllowInsecureGRPC lets the source be used as gRPC per-RPC credentials on connections
+	// without transport security
+	AllowInsecureGRPC bool
+}
+
+// ServiceTokenSource mints access tokens for service-to-service calls, caching each until
+// shortly before it expires. It can be used by TransportDestination.TokenSource and as gRPC
+// per-RPC credentials.
+type ServiceTokenSource struct {
+	opts ServiceTokenSourceOpts
+	now  func() time.Time
+
+	mu         sync.Mutex
+	token      string
+	expiresAt  time.Time
+	refreshAt  time.Time
+	refreshing chan struct{}
+	err        error
+}
+
+// NewServiceTokenSource creates a ServiceTokenSource
+func NewServiceTokenSource(opts ServiceTokenSourceOpts) (*ServiceTokenSource, error) {
+	if opts.Identity == "" {
+		return nil, ErrNoServiceIdentity
+	}
+
+	if opts.PrivateKey == nil {
+		return nil, ErrNoKey
+	}
+
+	if opts.Lifetime <= 0 {
+		opts.Lifetime = defaultServiceTokenLifetime
+	}
+
+	if opts.RefreshBefore <= 0 {
+		opts.RefreshBefore = defaultServiceTokenRefreshBefore
+	}
+
+	if opts.RefreshBefore >= opts.Lifetime {
+		return nil, ErrInvalidTokenRefresh
+	}
+
+	return &ServiceTokenSource{
+		opts: opts,
+		now:  time.Now,
+	}, nil
+}
+
+// Token returns the cached token, minting a new one when it is due for refresh. Concurrent
+// callers share a single refresh; while it is in progress callers are handed the current token
+// if it has not yet expired.
+func (s *ServiceTokenSource) Token(ctx context.Context) (string, error) {
+	for {
+		s.mu.Lock()
+		now := s.now()
+
+		if s.token != "" && now.Before(s.refreshAt) {
+			token := s.token
+			s.mu.Unlock()
+
+			return token, nil
+		}
+
+		if s.refreshing == nil {
+			break
+		}
+
+		if s.token != "" && now.Before(s.expiresAt) {
+			token := s.token
+			s.mu.Unlock()
+
+			return token, nil
+		}
+
+		// wait for the refresh in progress
+		refreshing := s.refreshing
+		s.mu.Unlock()
+
+		select {
+		case <-refreshing:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+
+		s.mu.Lock()
+		err := s.err
+		s.mu.Unlock()
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	refreshing := make(chan struct{})
+	s.refreshing = refreshing
+	s.mu.Unlock()
+
+	token, expiresAt, err := s.mint()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refreshing = nil
+	s.err = err
+
+	close(refreshing)
+
+	if err != nil {
+		return "", err
+	}
+
+	s.token = token
+	s.expiresAt = expiresAt
+	s.refreshAt = expiresAt.Add(-s.opts.RefreshBefore)
+
+	return token, nil
+}
+
+func (s *ServiceTokenSource) mint() (string, time.Time, error) {
+	jti, err := SecureRandomHex(16)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "SecureRandomHex")
+	}
+
+	now := s.now()
+	expiresAt := now.Add(s.opts.Lifetime)
+
+	token, err := CreateJWT(Claims{
+		Type:     string(JWTAccess),
+		UserID:   s.opts.UserID,
+		Username: s.opts.Identity,
+		Scope:    s.opts.Scope,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   s.opts.Identity,
+			Issuer:    s.opts.Issuer,
+			Audience:  s.opts.Audience,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}, s.opts.PrivateKey)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "CreateJWT")
+	}
+
+	return token, expiresAt, nil
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials
+func (s *ServiceTokenSource) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := s.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		grpcAuthorizationMetadataKey: bearerPrefix + token,
+	}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials
+func (s *ServiceTokenSource) RequireTransportSecurity() bool {
+	return !s.opts.AllowInsecureGRPC
+}
@@ -0,0 +1,145 @@
+package webutils
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewServiceTokenSource(t *testing.T) {
+	_, err := NewServiceTokenSource(ServiceTokenSourceOpts{PrivateKey: testPrivateKey})
+	assert.Equal(t, ErrNoServiceIdentity, err)
+
+	_, err = NewServiceTokenSource(ServiceTokenSourceOpts{Identity: "worker"})
+	assert.Equal(t, ErrNoKey, err)
+
+	_, err = NewServiceTokenSource(ServiceTokenSourceOpts{
+		Identity:      "worker",
+		PrivateKey:    testPrivateKey,
+		Lifetime:      time.Minute,
+		RefreshBefore: time.Minute,
+	})
+	assert.Equal(t, ErrInvalidTokenRefresh, err)
+}
+
+func Test_ServiceTokenSource_Token(t *testing.T) {
+	s, err := NewServiceTokenSource(ServiceTokenSourceOpts{
+		Identity:   "worker",
+		Issuer:     "cyberhorsey",
+		Scope:      "reports:read",
+		PrivateKey: testPrivateKey,
+	})
+	assert.Nil(t, err)
+
+	token, err := s.Token(context.Background())
+	assert.Nil(t, err)
+
+	claims, err := GetClaimsFromJWT(token, &testPrivateKey.PublicKey)
+	assert.Nil(t, err)
+	assert.Equal(t, string(JWTAccess), claims.Type)
+	assert.Equal(t, "worker", claims.Subject)
+	assert.Equal(t, "worker", claims.Username)
+	assert.Equal(t, "cyberhorsey", claims.Issuer)
+	assert.True(t, claims.HasScope("reports:read"))
+	assert.NotEqual(t, "", claims.Id)
+
+	// cached until shortly before expiry
+	cached, err := s.Token(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, token, cached)
+
+	// once within RefreshBefore of expiry, a new token is minted
+	s.mu.Lock()
+	assert.Equal(t, s.expiresAt.Add(-defaultServiceTokenRefreshBefore), s.refreshAt)
+	s.refreshAt = time.Now()
+	s.mu.Unlock()
+
+	refreshed, err := s.Token(context.Background())
+	assert.Nil(t, err)
+	assert.NotEqual(t, token, refreshed)
+}
+
+func Test_ServiceTokenSource_Concurrent(t *testing.T) {
+	s, err := NewServiceTokenSource(ServiceTokenSourceOpts{Identity: "worker", PrivateKey: testPrivateKey})
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+
+	tokens := make([]string, 20)
+
+	for i := range tokens {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			token, err := s.Token(context.Background())
+			assert.Nil(t, err)
+
+			tokens[i] = token
+		}(i)
+	}
+
+	wg.Wait()
+
+	// a single token was minted and shared by every caller
+	for _, token := range tokens {
+		assert.Equal(t, tokens[0], token)
+	}
+}
+
+func Test_ServiceTokenSource_PerRPCCredentials(t *testing.T) {
+	s, err := NewServiceTokenSource(ServiceTokenSourceOpts{Identity: "worker", PrivateKey: testPrivateKey})
+	assert.Nil(t, err)
+	assert.True(t, s.RequireTransportSecurity())
+
+	md, err := s.GetRequestMetadata(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(md["authorization"], "Bearer "))
+
+	insecure, err := NewServiceTokenSource(ServiceTokenSourceOpts{
+		Identity:          "worker",
+		PrivateKey:        testPrivateKey,
+		AllowInsecureGRPC: true,
+	})
+	assert.Nil(t, err)
+	assert.False(t, insecure.RequireTransportSecurity())
+}
+
+func Test_PropagationTransport_TokenSource(t *testing.T) {
+	s, err := NewServiceTokenSource(ServiceTokenSourceOpts{Identity: "worker", PrivateKey: testPrivateKey})
+	assert.Nil(t, err)
+
+	base := &recordingTransport{}
+
+	rt, err := NewPropagationTransport(PropagationTransportOpts{
+		Base:         base,
+		Destinations: []TransportDestination{{Host: "users.internal", ForwardJWT: true, TokenSource: s}},
+	})
+	assert.Nil(t, err)
+
+	serviceToken, err := s.Token(context.Background())
+	assert.Nil(t, err)
+
+	u, err := url.Parse("https://users.internal/users")
+	assert.Nil(t, err)
+
+	// background work without a user JWT uses the service token
+	req := &http.Request{Method: http.MethodGet, URL: u, Header: http.Header{}}
+	_, err = rt.RoundTrip(req.WithContext(context.Background()))
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer "+serviceToken, base.req.Header.Get(echo.HeaderAuthorization))
+
+	// a user JWT in the context is forwarded instead
+	ctx := newJWTContext(context.Background(), &Claims{}, "user-token")
+	_, err = rt.RoundTrip(req.WithContext(ctx))
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer user-token", base.req.Header.Get(echo.HeaderAuthorization))
+}
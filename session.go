@@ -0,0 +1,353 @@
+package webutils
+
+import (
+	"context"
+	"crypto/rsa"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cyberhorsey/errors"
+	jwt "github.com/golang-jwt/jwt/v4"
+	echo "github.com/labstack/echo/v4"
+)
+
+const (
+	defaultSessionCookieName  = "session"
+	defaultSessionLifetime    = 30 * time.Minute
+	defaultSessionMaxLifetime = 12 * time.Hour
+	revocationPruneEvery      = time.Minute
+)
+
+// RevocationStore records revoked tokens by jti until they would have expired
+type RevocationStore interface {
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	Revoked(ctx context.Context, jti string) (bool, error)
+}
+
+// InMemoryRevocationStore is a RevocationStore for a single instance; deployments with several
+// instances need a shared store so a session revoked on one is revoked on all.
+type InMemoryRevocationStore struct {
+	mu       sync.Mutex
+	jtis     map[string]time.Time
+	now      func() time.Time
+	prunedAt time.Time
+}
+
+// NewInMemoryRevocationStore creates an empty InMemoryRevocationStore
+func NewInMemoryRevocationStore() *InMemoryRevocationStore {
+	return &InMemoryRevocationStore{
+		jtis: make(map[string]time.Time),
+		now:  time.Now,
+	}
+}
+
+// Revoke implements RevocationStore
+func (s *InMemoryRevocationStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	if now.Sub(s.prunedAt) >= revocationPruneEvery {
+		for k, exp := range s.jtis {
+			if !now.Before(exp) {
+				delete(s.jtis, k)
+			}
+		}
+
+		s.prunedAt = now
+	}
+
+	s.jtis[jti] = expiresAt
+
+	return nil
+}
+
+// Revoked implements RevocationStore
+func (s *InMemoryRevocationStore) Revoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exp, ok := s.jtis[jti]
+
+	return ok && s.now().Before(exp), nil
+}
+
+// SessionManagerOpts contains the options for NewSessionManager
+type SessionManagerOpts struct {
+	// PrivateKey signs the session tokens, as with CreateJWT
+	PrivateKey *rsa.PrivateKey
+	Issuer     string
+	// CookieName defaults to "session"
+	CookieName string
+	Domain     string
+	// Path defaults to "/"
+	Path string
+	// SameSite defaults to http.SameSiteLaxMode
+	SameSite http.SameSite
+	// Insecure omits the Secure cookie attribute, for local development over http only
+	Insecure bool
+	// Lifetime is how long a session lasts without requests. Sessions used in the second half of
+	// their lifetime are renewed. Defaults to 30 minutes.
+	Lifetime time.Duration
+	// MaxLifetime is how long after signing in a session ends regardless of renewals. Defaults to
+	// 12 hours.
+	MaxLifetime time.Duration
+	// Revocations records logged out sessions. Defaults to an InMemoryRevocationStore.
+	Revocations RevocationStore
+}
+
+// SessionManager issues, renews and revokes JWT sessions kept in Secure, HttpOnly cookies, for
+// server-rendered UIs which can't safely keep a bearer token in JavaScript. Cookie sessions must
+// be used together with CSRF protection, see ConfigureCSRFMiddleware.
+type SessionManager struct {
+	opts SessionManagerOpts
+	now  func() time.Time
+}
+
+// NewSessionManager creates a SessionManager
+func NewSessionManager(opts SessionManagerOpts) (*SessionManager, error) {
+	if opts.PrivateKey == nil {
+		return nil, ErrNoKey
+	}
+
+	if opts.CookieName == "" {
+		opts.CookieName = defaultSessionCookieName
+	}
+
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+
+	if opts.SameSite == 0 {
+		opts.SameSite = http.SameSiteLaxMode
+	}
+
+	if opts.Lifetime <= 0 {
+		opts.Lifetime = defaultSessionLifetime
+	}
+
+	if opts.MaxLifetime <= 0 {
+		opts.MaxLifetime = defaultSessionMaxLifetime
+	}
+
+	if opts.Lifetime > opts.MaxLifetime {
+		return nil, ErrInvalidSessionLifetime
+	}
+
+	if opts.Revocations == nil {
+		opts.Revocations = NewInMemoryRevocationStore()
+	}
+
+	return &SessionManager{
+		opts: opts,
+		now:  time.Now,
+	}, nil
+}
+
+// Issue starts a session for the claims, ie: after signing in, setting the session cookie
+func (m *SessionManager) Issue(c echo.Context, claims Claims) error {
+	sid, err := SecureRandomHex(16)
+	if err != nil {
+		return errors.Wrap(err, "SecureRandomHex")
+	}
+
+	claims.AuthTime = m.now().Unix()
+	claims.SessionID = sid
+
+	return m.issue(c, claims)
+}
+
+func (m *SessionManager) issue(c echo.Context, claims Claims) error {
+	jti, err := SecureRandomHex(16)
+	if err != nil {
+		return errors.Wrap(err, "SecureRandomHex")
+	}
+
+	now := m.now()
+
+	expiresAt := now.Add(m.opts.Lifetime)
+	if maxExpiresAt := time.Unix(claims.AuthTime, 0).Add(m.opts.MaxLifetime); maxExpiresAt.Before(expiresAt) {
+		expiresAt = maxExpiresAt
+	}
+
+	claims.Type = string(JWTSession)
+	claims.Id = jti
+	claims.Issuer = m.opts.Issuer
+	claims.IssuedAt = now.Unix()
+	claims.NotBefore = now.Unix()
+	claims.ExpiresAt = expiresAt.Unix()
+
+	token, err := CreateJWT(claims, m.opts.PrivateKey)
+	if err != nil {
+		return errors.Wrap(err, "CreateJWT")
+	}
+
+	c.SetCookie(m.cookie(token, expiresAt))
+
+	return nil
+}
+
+// Logout revokes the session in the request, if any, including the tokens it was renewed from,
+// and clears the session cookie
+func (m *SessionManager) Logout(c echo.Context) error {
+	cookie, err := c.Cookie(m.opts.CookieName)
+	if err == nil {
+		// a session which is invalid anyway has nothing to revoke
+		if claims, err := m.parse(cookie.Value); err == nil {
+			if err := m.opts.Revocations.Revoke(
+				c.Request().Context(),
+				sessionID(claims),
+				m.sessionExpiresAt(claims),
+			); err != nil {
+				return errors.Wrap(err, "m.opts.Revocations.Revoke")
+			}
+		}
+	}
+
+	expired := m.cookie("", time.Unix(0, 0))
+	expired.MaxAge = -1
+
+	c.SetCookie(expired)
+
+	return nil
+}
+
+func (m *SessionManager) cookie(value string, expiresAt time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     m.opts.CookieName,
+		Value:    value,
+		Path:     m.opts.Path,
+		Domain:   m.opts.Domain,
+		Expires:  expiresAt,
+		Secure:   !m.opts.Insecure,
+		HttpOnly: true,
+		SameSite: m.opts.SameSite,
+	}
+}
+
+// sessionID returns the id revocations of the session are recorded under, which is the jti for
+// sessions issued without a session id
+func sessionID(claims *Claims) string {
+	if claims.SessionID != "" {
+		return claims.SessionID
+	}
+
+	return claims.Id
+}
+
+// sessionExpiresAt returns when the last token a session can be renewed to expires
+func (m *SessionManager) sessionExpiresAt(claims *Claims) time.Time {
+	if claims.SessionID == "" {
+		return time.Unix(claims.ExpiresAt, 0)
+	}
+
+	return time.Unix(claims.AuthTime, 0).Add(m.opts.MaxLifetime)
+}
+
+// parse validates a session token, returning its claims
+func (m *SessionManager) parse(token string) (*Claims, error) {
+	claims, err := ParseJWT(token, JWTParseOpts{
+		KeyFunc:        publicKeyFunc(&m.opts.PrivateKey.PublicKey),
+		Issuer:         m.opts.Issuer,
+		SigningMethods: []string{jwt.SigningMethodRS512.Alg()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Type != string(JWTSession) || claims.Id == "" {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// authenticate validates the session token, renewing it when due
+func (m *SessionManager) authenticate(c echo.Context, token string) (*Claims, error) {
+	claims, err := m.parse(token)
+	if err != nil {
+		return nil, errors.WithCause(ErrSessionInvalid, err)
+	}
+
+	revoked, err := m.opts.Revocations.Revoked(c.Request().Context(), sessionID(claims))
+	if err != nil {
+		return nil, errors.Wrap(err, "m.opts.Revocations.Revoked")
+	}
+
+	if revoked {
+		return nil, ErrSessionInvalid
+	}
+
+	// sliding renewal once half the lifetime has passed
+	if time.Unix(claims.ExpiresAt, 0).Sub(m.now()) < m.opts.Lifetime/2 {
+		if err := m.issue(c, *claims); err != nil {
+			return nil, err
+		}
+	}
+
+	return claims, nil
+}
+
+// SessionMiddlewareOpts contains the options for ConfigureSessionMiddleware
+type SessionMiddlewareOpts struct {
+	Manager *SessionManager
+	Skipper func(c echo.Context) bool
+	// Optional lets requests without a session cookie through without claims
+	Optional bool
+}
+
+// sessionMiddleware authenticates requests by session cookie
+type sessionMiddleware struct {
+	Manager  *SessionManager
+	Skipper  func(c echo.Context) bool
+	Optional bool
+}
+
+// ConfigureSessionMiddleware configures middleware authenticating requests by their session
+// cookie, populating the context with its claims like the JWT middleware. The cookie itself isn't
+// stored as the JWT, so it's never forwarded.
+func ConfigureSessionMiddleware(opts SessionMiddlewareOpts) (echo.MiddlewareFunc, error) {
+	mw := sessionMiddleware(opts)
+	if mw.Manager == nil {
+		return nil, ErrNoSessionManager
+	}
+
+	if mw.Skipper == nil {
+		mw.Skipper = defaultJWTMiddlewareSkipper
+	}
+
+	return mw.Handler, nil
+}
+
+func (mw *sessionMiddleware) Handler(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if mw.Skipper(c) {
+			return next(c)
+		}
+
+		cookie, err := c.Cookie(mw.Manager.opts.CookieName)
+		if err != nil || cookie.Value == "" {
+			if mw.Optional {
+				return next(c)
+			}
+
+			return LogAndRenderErrors(c, http.StatusUnauthorized, ErrSessionRequired)
+		}
+
+		claims, err := mw.Manager.authenticate(c, cookie.Value)
+		if err != nil {
+			if errors.GetType(err) != errors.NoType {
+				return LogAndRenderErrors(c, ConvertErrorToStatusCode(err), err)
+			}
+
+			return LogAndRenderUnexpectedError(c, err)
+		}
+
+		// the HttpOnly cookie must not be forwarded, ie: by PropagationTransport as a bearer token
+		setJWTClaimsContext(c, claims)
+
+		return next(c)
+	}
+}
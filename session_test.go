@@ -0,0 +1,191 @@
+package webutils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewSessionManager(t *testing.T) {
+	_, err := NewSessionManager(SessionManagerOpts{})
+	assert.Equal(t, ErrNoKey, err)
+
+	_, err = NewSessionManager(SessionManagerOpts{
+		PrivateKey:  testPrivateKey,
+		Lifetime:    time.Hour,
+		MaxLifetime: time.Minute,
+	})
+	assert.Equal(t, ErrInvalidSessionLifetime, err)
+
+	_, err = ConfigureSessionMiddleware(SessionMiddlewareOpts{})
+	assert.Equal(t, ErrNoSessionManager, err)
+}
+
+func Test_InMemoryRevocationStore(t *testing.T) {
+	now := time.Now()
+	s := NewInMemoryRevocationStore()
+	s.now = func() time.Time { return now }
+
+	assert.Nil(t, s.Revoke(context.Background(), "a", now.Add(time.Minute)))
+
+	revoked, err := s.Revoked(context.Background(), "a")
+	assert.Nil(t, err)
+	assert.True(t, revoked)
+
+	now = now.Add(2 * time.Minute)
+	revoked, _ = s.Revoked(context.Background(), "a")
+	assert.False(t, revoked)
+}
+
+func Test_SessionManager(t *testing.T) {
+	m, err := NewSessionManager(SessionManagerOpts{PrivateKey: testPrivateKey, Issuer: "cyberhorsey"})
+	assert.Nil(t, err)
+
+	mw, err := ConfigureSessionMiddleware(SessionMiddlewareOpts{Manager: m})
+	assert.Nil(t, err)
+
+	e := echo.New()
+	e.POST("/login", func(c echo.Context) error {
+		if err := m.Issue(c, Claims{UserID: 42, Username: "admin"}); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	})
+	e.POST("/logout", func(c echo.Context) error {
+		if err := m.Logout(c); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	})
+	e.GET("/admin", func(c echo.Context) error {
+		claims, err := GetJWTClaimsFromContext(c.Request().Context())
+		if err != nil {
+			return err
+		}
+
+		// the session cookie isn't exposed as a JWT to forward
+		if _, err := GetJWTFromContext(c.Request().Context()); err == nil {
+			return c.NoContent(http.StatusInternalServerError)
+		}
+
+		if _, err := GetJWTFromEchoContext(c); err == nil {
+			return c.NoContent(http.StatusInternalServerError)
+		}
+
+		return c.String(http.StatusOK, claims.Username)
+	}, mw)
+
+	serve := func(method, path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	sessionCookie := func(rec *httptest.ResponseRecorder) *http.Cookie {
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == defaultSessionCookieName {
+				return cookie
+			}
+		}
+
+		return nil
+	}
+
+	rec := serve(http.MethodGet, "/admin", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = serve(http.MethodPost, "/login", nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	session := sessionCookie(rec)
+	assert.NotNil(t, session)
+	assert.True(t, session.Secure)
+	assert.True(t, session.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, session.SameSite)
+
+	rec = serve(http.MethodGet, "/admin", session)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "admin", rec.Body.String())
+
+	// not renewed in the first half of its lifetime
+	assert.Nil(t, sessionCookie(rec))
+
+	// session tokens aren't accepted as bearer tokens
+	bearer, err := ConfigureJWTMiddleware(JWTMiddlewareOpts{PublicKey: testPublicKeyFunc})
+	assert.Nil(t, err)
+
+	bearerRec, _ := serveJWTMiddleware(t, bearer, "Bearer "+session.Value)
+	assert.Equal(t, http.StatusUnauthorized, bearerRec.Code)
+
+	// renewed in the second half
+	m.now = func() time.Time { return time.Now().Add(-20 * time.Minute) }
+	old := sessionCookie(serve(http.MethodPost, "/login", nil))
+	m.now = time.Now
+
+	rec = serve(http.MethodGet, "/admin", old)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	renewed := sessionCookie(rec)
+	assert.NotNil(t, renewed)
+	assert.NotEqual(t, old.Value, renewed.Value)
+
+	rec = serve(http.MethodPost, "/logout", renewed)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	cleared := sessionCookie(rec)
+	assert.NotNil(t, cleared)
+	assert.Equal(t, "", cleared.Value)
+	assert.True(t, cleared.MaxAge < 0)
+
+	// the logged out session is revoked, as is the token it was renewed from
+	for _, cookie := range []*http.Cookie{renewed, old} {
+		rec = serve(http.MethodGet, "/admin", cookie)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), "ERR_SESSION_INVALID")
+	}
+
+	// other sessions are unaffected
+	rec = serve(http.MethodGet, "/admin", session)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func Test_SessionManager_MaxLifetime(t *testing.T) {
+	m, err := NewSessionManager(SessionManagerOpts{
+		PrivateKey:  testPrivateKey,
+		Lifetime:    30 * time.Minute,
+		MaxLifetime: 40 * time.Minute,
+	})
+	assert.Nil(t, err)
+
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/login", nil), httptest.NewRecorder())
+
+	authTime := time.Now().Add(-20 * time.Minute)
+
+	assert.Nil(t, m.issue(c, Claims{UserID: 42, AuthTime: authTime.Unix()}))
+
+	cookies := c.Response().Header()["Set-Cookie"]
+	assert.Len(t, cookies, 1)
+
+	req := &http.Request{Header: http.Header{"Cookie": cookies}}
+	cookie, err := req.Cookie(defaultSessionCookieName)
+	assert.Nil(t, err)
+
+	claims, err := m.parse(cookie.Value)
+	assert.Nil(t, err)
+
+	// renewals never extend past MaxLifetime after signing in
+	assert.Equal(t, authTime.Add(40*time.Minute).Unix(), claims.ExpiresAt)
+}
@@ -0,0 +1,255 @@
+package webutils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	qerrors "github.com/cyberhorsey/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const spanKey ctxKey = ctxKey(4203)
+
+// Span kinds
+const (
+	SpanKindServer   = "server"
+	SpanKindInternal = "internal"
+)
+
+// SpanExporter receives ended spans, ie: to send them to a tracing backend. ExportSpans is called
+// as spans end, so implementations must be safe for concurrent use and should not block.
+type SpanExporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+}
+
+var (
+	spanExporterMu sync.RWMutex
+	spanExporter   SpanExporter
+)
+
+// SetSpanExporter sets the exporter ended spans are sent to. Spans are recorded but discarded
+// until an exporter is set.
+func SetSpanExporter(exporter SpanExporter) {
+	spanExporterMu.Lock()
+	defer spanExporterMu.Unlock()
+
+	spanExporter = exporter
+}
+
+func currentSpanExporter() SpanExporter {
+	spanExporterMu.RLock()
+	defer spanExporterMu.RUnlock()
+
+	return spanExporter
+}
+
+// SpanData is a snapshot of a span, as exported
+type SpanData struct {
+	Name         string                 `json:"name"`
+	Kind         string                 `json:"kind"`
+	TraceID      string                 `json:"traceId"`
+	SpanID       string                 `json:"spanId"`
+	ParentSpanID string                 `json:"parentSpanId,omitempty"`
+	ProvenanceID string                 `json:"provenanceId,omitempty"`
+	RequestID    string                 `json:"requestId,omitempty"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Events       []SpanEvent            `json:"events,omitempty"`
+	// Error is the message of the last error recorded, and ErrorType its qerrors type
+	Error     string `json:"error,omitempty"`
+	ErrorType string `json:"errorType,omitempty"`
+}
+
+// Duration is how long the span took
+func (d SpanData) Duration() time.Duration {
+	return d.End.Sub(d.Start)
+}
+
+// SpanEvent is something that happened during a span
+type SpanEvent struct {
+	Name       string                 `json:"name"`
+	Time       time.Time              `json:"time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Span is a timed operation within a trace. Its methods are safe for concurrent use, and do
+// nothing on a nil *Span, so callers needn't check whether tracing is set up.
+type Span struct {
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// StartSpan starts a span named name, a child of the span in ctx, or the root of a new trace when
+// ctx has no trace context. The returned context carries the span, and its trace context refers
+// to it so calls made with it are children of the span. End must be called to export the span.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	tc, ok := TraceContextFromContext(ctx)
+	if !ok {
+		tc = TraceContext{TraceID: newTraceID(), Flags: TraceFlagSampled}
+	}
+
+	tc.ParentSpanID, tc.SpanID = tc.SpanID, newSpanID()
+
+	return startSpan(ctx, tc, name, SpanKindInternal)
+}
+
+// startSpan starts the span identified by tc
+func startSpan(ctx context.Context, tc TraceContext, name, kind string) (context.Context, *Span) {
+	pid, _ := ProvenanceIDFromContext(ctx)
+	rid, _ := RequestIDFromContext(ctx)
+
+	span := &Span{data: SpanData{
+		Name:         name,
+		Kind:         kind,
+		TraceID:      tc.TraceID,
+		SpanID:       tc.SpanID,
+		ParentSpanID: tc.ParentSpanID,
+		ProvenanceID: pid,
+		RequestID:    rid,
+		Start:        time.Now(),
+		Attributes:   make(map[string]interface{}),
+	}}
+
+	ctx = WithTraceContext(ctx, tc)
+	ctx = context.WithValue(ctx, spanKey, span)
+
+	return ctx, span
+}
+
+// SpanFromContext returns the current span from context, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// SetAttribute records a key/value describing the span, ie: "db.table" or "http.status_code"
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Attributes[key] = value
+}
+
+// AddEvent records something that happened during the span
+func (s *Span) AddEvent(name string, attributes map[string]interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Events = append(s.data.Events, SpanEvent{Name: name, Time: time.Now(), Attributes: attributes})
+}
+
+// RecordError marks the span as failed with err, tagged with its qerrors type
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	// rendered errors carry the typed error as their cause
+	if werr, ok := err.(Error); ok && werr.Cause != nil {
+		err = werr.Cause
+	}
+
+	errorType := errorTypeName(qerrors.GetType(err))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Error = err.Error()
+	s.data.ErrorType = errorType
+	s.data.Events = append(s.data.Events, SpanEvent{
+		Name: "error",
+		Time: time.Now(),
+		Attributes: map[string]interface{}{
+			"error.message": err.Error(),
+			"error.type":    errorType,
+			"error.key":     qerrors.Key(err),
+		},
+	})
+}
+
+// End ends the span and exports it. Only the first call has any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.snapshot()
+
+	s.mu.Unlock()
+
+	exporter := currentSpanExporter()
+	if exporter == nil {
+		return
+	}
+
+	if err := exporter.ExportSpans(context.Background(), []SpanData{data}); err != nil {
+		logger.WithFields(spanLogFields(data)).Error(qerrors.Wrap(err, "exporter.ExportSpans"))
+	}
+}
+
+// snapshot copies the span's data so exporters can't race with further changes
+func (s *Span) snapshot() SpanData {
+	data := s.data
+
+	data.Attributes = make(map[string]interface{}, len(s.data.Attributes))
+	for k, v := range s.data.Attributes {
+		data.Attributes[k] = v
+	}
+
+	data.Events = append([]SpanEvent(nil), s.data.Events...)
+
+	return data
+}
+
+func spanLogFields(data SpanData) logrus.Fields {
+	return logrus.Fields{
+		"provenanceId": data.ProvenanceID,
+		"requestId":    data.RequestID,
+		"traceId":      data.TraceID,
+		"spanId":       data.SpanID,
+	}
+}
+
+// errorTypeName names a qerrors.ErrorType
+func errorTypeName(errorType qerrors.ErrorType) string {
+	switch errorType {
+	case qerrors.NotFound:
+		return "NotFound"
+	case qerrors.InvalidParameter:
+		return "InvalidParameter"
+	case qerrors.MissingParameter:
+		return "MissingParameter"
+	case qerrors.Validation:
+		return "Validation"
+	case qerrors.Forbidden:
+		return "Forbidden"
+	case qerrors.Public:
+		return "Public"
+	case qerrors.BadRequest:
+		return "BadRequest"
+	case qerrors.Unauthorized:
+		return "Unauthorized"
+	}
+
+	return "NoType"
+}
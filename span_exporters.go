@@ -0,0 +1,459 @@
+package webutils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cyberhorsey/errors"
+	echo "github.com/labstack/echo/v4"
+)
+
+const (
+	defaultOTLPBatchSize     = 512
+	defaultOTLPFlushInterval = 5 * time.Second
+	// maxOTLPBufferedSpans bounds the spans buffered while the collector is unreachable
+	maxOTLPBufferedSpans = 10000
+	otlpScopeName        = "github.com/cyberhorsey/webutils"
+)
+
+// InMemorySpanExporter keeps exported spans in memory, for tests
+type InMemorySpanExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewInMemorySpanExporter creates an empty InMemorySpanExporter
+func NewInMemorySpanExporter() *InMemorySpanExporter {
+	return &InMemorySpanExporter{}
+}
+
+// ExportSpans implements SpanExporter
+func (e *InMemorySpanExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = append(e.spans, spans...)
+
+	return nil
+}
+
+// Spans returns the spans exported so far, in the order they ended
+func (e *InMemorySpanExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset discards the spans exported so far
+func (e *InMemorySpanExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = nil
+}
+
+// JSONSpanExporter writes each span as a line of JSON
+type JSONSpanExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONSpanExporter creates a JSONSpanExporter writing to w
+func NewJSONSpanExporter(w io.Writer) *JSONSpanExporter {
+	return &JSONSpanExporter{w: w}
+}
+
+// NewStdoutSpanExporter creates a JSONSpanExporter writing to stdout, ie: for log collectors
+func NewStdoutSpanExporter() *JSONSpanExporter {
+	return NewJSONSpanExporter(os.Stdout)
+}
+
+// ExportSpans implements SpanExporter
+func (e *JSONSpanExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	enc := json.NewEncoder(e.w)
+
+	for _, span := range spans {
+		if err := enc.Encode(span); err != nil {
+			return errors.Wrap(err, "enc.Encode")
+		}
+	}
+
+	return nil
+}
+
+// OTLPHTTPSpanExporterOpts contains the options for NewOTLPHTTPSpanExporter
+type OTLPHTTPSpanExporterOpts struct {
+	// Endpoint is the collector's traces URL, ie: "http://otel-collector:4318/v1/traces"
+	Endpoint string
+	// ServiceName is reported as the service.name resource attribute
+	ServiceName string
+	// Headers are added to each export request, ie: for collector authentication
+	Headers map[string]string
+	// Client defaults to an http.Client with a 10 second timeout
+	Client *http.Client
+	// BatchSize is the number of spans that triggers an export. Defaults to 512.
+	BatchSize int
+	// FlushInterval is how often buffered spans are exported. Defaults to 5 seconds.
+	FlushInterval time.Duration
+}
+
+// OTLPHTTPSpanExporter batches spans and exports them to an OpenTelemetry collector with the
+// OTLP/HTTP JSON encoding. Spans are exported in the background, so ExportSpans never waits on
+// the collector; Shutdown exports the remaining spans.
+type OTLPHTTPSpanExporter struct {
+	endpoint    string
+	serviceName string
+	headers     map[string]string
+	client      *http.Client
+	batchSize   int
+
+	mu       sync.Mutex
+	buffer   []SpanData
+	flushCh  chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewOTLPHTTPSpanExporter creates an OTLPHTTPSpanExporter and starts its background exports
+func NewOTLPHTTPSpanExporter(opts OTLPHTTPSpanExporterOpts) (*OTLPHTTPSpanExporter, error) {
+	if opts.Endpoint == "" {
+		return nil, ErrNoOTLPEndpoint
+	}
+
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultOTLPBatchSize
+	}
+
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultOTLPFlushInterval
+	}
+
+	e := &OTLPHTTPSpanExporter{
+		endpoint:    opts.Endpoint,
+		serviceName: opts.ServiceName,
+		headers:     opts.Headers,
+		client:      opts.Client,
+		batchSize:   opts.BatchSize,
+		flushCh:     make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	go e.run(opts.FlushInterval)
+
+	return e, nil
+}
+
+// ExportSpans implements SpanExporter by buffering spans for the next export
+func (e *OTLPHTTPSpanExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// drop the oldest spans rather than grow without bound while the collector is down
+	e.buffer = append(e.buffer, spans...)
+	if over := len(e.buffer) - maxOTLPBufferedSpans; over > 0 {
+		e.buffer = e.buffer[over:]
+	}
+
+	if len(e.buffer) >= e.batchSize {
+		select {
+		case e.flushCh <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Flush exports the buffered spans
+func (e *OTLPHTTPSpanExporter) Flush(ctx context.Context) error {
+	e.mu.Lock()
+	spans := e.buffer
+	e.buffer = nil
+	e.mu.Unlock()
+
+	for len(spans) > 0 {
+		n := e.batchSize
+		if n > len(spans) {
+			n = len(spans)
+		}
+
+		if err := e.send(ctx, spans[:n]); err != nil {
+			// keep the unsent spans for the next export
+			e.requeue(spans)
+
+			return err
+		}
+
+		spans = spans[n:]
+	}
+
+	return nil
+}
+
+// requeue puts spans back ahead of those buffered since, dropping the oldest beyond the bound
+func (e *OTLPHTTPSpanExporter) requeue(spans []SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.buffer = append(append([]SpanData(nil), spans...), e.buffer...)
+	if over := len(e.buffer) - maxOTLPBufferedSpans; over > 0 {
+		e.buffer = e.buffer[over:]
+	}
+}
+
+// Shutdown stops the background exports and exports the remaining spans. It may be called more
+// than once.
+func (e *OTLPHTTPSpanExporter) Shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() {
+		close(e.stop)
+	})
+
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return e.Flush(ctx)
+}
+
+func (e *OTLPHTTPSpanExporter) run(interval time.Duration) {
+	defer close(e.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+		case <-e.flushCh:
+		}
+
+		if err := e.Flush(context.Background()); err != nil {
+			logger.Error(errors.Wrap(err, "e.Flush"))
+		}
+	}
+}
+
+func (e *OTLPHTTPSpanExporter) send(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "http.NewRequestWithContext")
+	}
+
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "e.client.Do")
+	}
+
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Wrapf(ErrOTLPExportFailed, "status %v", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// OTLP/HTTP JSON request, see
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/trace/v1/trace.proto
+type otlpTraceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Message string `json:"message,omitempty"`
+	Code    int    `json:"code"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// OTLP span kinds and status codes
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpStatusCodeUnset  = 0
+	otlpStatusCodeError  = 2
+)
+
+func (e *OTLPHTTPSpanExporter) request(spans []SpanData) otlpTraceRequest {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+
+	for _, s := range spans {
+		kind := otlpSpanKindInternal
+		if s.Kind == SpanKindServer {
+			kind = otlpSpanKindServer
+		}
+
+		attributes := map[string]interface{}{}
+		for k, v := range s.Attributes {
+			attributes[k] = v
+		}
+
+		if s.ProvenanceID != "" {
+			attributes["provenance.id"] = s.ProvenanceID
+		}
+
+		if s.RequestID != "" {
+			attributes["request.id"] = s.RequestID
+		}
+
+		if s.ErrorType != "" {
+			attributes["error.type"] = s.ErrorType
+		}
+
+		// spans are only marked OK by the instrumentation, which never does so here
+		status := otlpStatus{Code: otlpStatusCodeUnset}
+		if s.Error != "" {
+			status = otlpStatus{Code: otlpStatusCodeError, Message: s.Error}
+		}
+
+		events := make([]otlpEvent, 0, len(s.Events))
+		for _, ev := range s.Events {
+			events = append(events, otlpEvent{
+				TimeUnixNano: unixNano(ev.Time),
+				Name:         ev.Name,
+				Attributes:   otlpAttributes(ev.Attributes),
+			})
+		}
+
+		otlpSpans = append(otlpSpans, otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentSpanID,
+			Name:              s.Name,
+			Kind:              kind,
+			StartTimeUnixNano: unixNano(s.Start),
+			EndTimeUnixNano:   unixNano(s.End),
+			Attributes:        otlpAttributes(attributes),
+			Events:            events,
+			Status:            status,
+		})
+	}
+
+	return otlpTraceRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: otlpAttributes(map[string]interface{}{
+			"service.name": e.serviceName,
+		})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: otlpScopeName},
+			Spans: otlpSpans,
+		}},
+	}}}
+}
+
+func otlpAttributes(attributes map[string]interface{}) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attributes))
+
+	for k, v := range attributes {
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: newOTLPValue(v)})
+	}
+
+	return kvs
+}
+
+func newOTLPValue(v interface{}) otlpValue {
+	switch value := v.(type) {
+	case bool:
+		return otlpValue{BoolValue: &value}
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32:
+		i := fmt.Sprint(value)
+		return otlpValue{IntValue: &i}
+	case float32:
+		f := float64(value)
+		return otlpValue{DoubleValue: &f}
+	case float64:
+		return otlpValue{DoubleValue: &value}
+	case string:
+		return otlpValue{StringValue: &value}
+	}
+
+	s := fmt.Sprint(v)
+
+	return otlpValue{StringValue: &s}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
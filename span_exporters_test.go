@@ -0,0 +1,175 @@
+package webutils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSpanData(name string) SpanData {
+	start := time.Unix(1700000000, 0)
+
+	return SpanData{
+		Name:         name,
+		Kind:         SpanKindServer,
+		TraceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:       "00f067aa0ba902b7",
+		ProvenanceID: "pid",
+		Start:        start,
+		End:          start.Add(time.Second),
+		Attributes:   map[string]interface{}{"http.status_code": 500, "cached": true},
+		Error:        "boom",
+		ErrorType:    "NoType",
+	}
+}
+
+func Test_InMemorySpanExporter(t *testing.T) {
+	e := NewInMemorySpanExporter()
+	assert.Nil(t, e.ExportSpans(context.Background(), []SpanData{newTestSpanData("a")}))
+	assert.Len(t, e.Spans(), 1)
+
+	e.Reset()
+	assert.Len(t, e.Spans(), 0)
+}
+
+func Test_JSONSpanExporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	e := NewJSONSpanExporter(buf)
+
+	assert.Nil(t, e.ExportSpans(context.Background(), []SpanData{newTestSpanData("a"), newTestSpanData("b")}))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	span := SpanData{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &span))
+	assert.Equal(t, "b", span.Name)
+	assert.Equal(t, "pid", span.ProvenanceID)
+	assert.Equal(t, "NoType", span.ErrorType)
+}
+
+func Test_OTLPHTTPSpanExporter(t *testing.T) {
+	_, err := NewOTLPHTTPSpanExporter(OTLPHTTPSpanExporterOpts{})
+	assert.Equal(t, ErrNoOTLPEndpoint, err)
+
+	var (
+		mu       sync.Mutex
+		requests []otlpTraceRequest
+		status   = http.StatusOK
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+
+		body, _ := ioutil.ReadAll(r.Body)
+		req := otlpTraceRequest{}
+		assert.Nil(t, json.Unmarshal(body, &req))
+
+		requests = append(requests, req)
+
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	succeeded := newTestSpanData("c")
+	succeeded.Error, succeeded.ErrorType = "", ""
+
+	e, err := NewOTLPHTTPSpanExporter(OTLPHTTPSpanExporterOpts{
+		Endpoint:      srv.URL,
+		ServiceName:   "users",
+		Headers:       map[string]string{"X-Api-Key": "secret"},
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	})
+	assert.Nil(t, err)
+
+	// a full batch is exported in the background
+	assert.Nil(t, e.ExportSpans(context.Background(), []SpanData{newTestSpanData("a"), newTestSpanData("b")}))
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(requests) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// the remainder is exported on shutdown
+	assert.Nil(t, e.ExportSpans(context.Background(), []SpanData{succeeded}))
+	assert.Nil(t, e.Shutdown(context.Background()))
+	assert.Nil(t, e.Shutdown(context.Background()))
+
+	mu.Lock()
+	assert.Len(t, requests, 2)
+
+	rs := requests[0].ResourceSpans[0]
+	assert.Equal(t, "service.name", rs.Resource.Attributes[0].Key)
+	assert.Equal(t, "users", *rs.Resource.Attributes[0].Value.StringValue)
+
+	spans := rs.ScopeSpans[0].Spans
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "a", spans[0].Name)
+	assert.Equal(t, otlpSpanKindServer, spans[0].Kind)
+	assert.Equal(t, "1700000000000000000", spans[0].StartTimeUnixNano)
+	assert.Equal(t, otlpStatusCodeError, spans[0].Status.Code)
+	assert.Equal(t, "boom", spans[0].Status.Message)
+
+	attributes := map[string]otlpValue{}
+	for _, kv := range spans[0].Attributes {
+		attributes[kv.Key] = kv.Value
+	}
+
+	assert.Equal(t, "500", *attributes["http.status_code"].IntValue)
+	assert.True(t, *attributes["cached"].BoolValue)
+	assert.Equal(t, "pid", *attributes["provenance.id"].StringValue)
+	assert.Equal(t, "NoType", *attributes["error.type"].StringValue)
+
+	c := requests[1].ResourceSpans[0].ScopeSpans[0].Spans[0]
+	assert.Equal(t, "c", c.Name)
+	assert.Equal(t, otlpStatusCodeUnset, c.Status.Code)
+
+	// failed exports are reported
+	status = http.StatusServiceUnavailable
+	mu.Unlock()
+
+	err = e.send(context.Background(), []SpanData{newTestSpanData("d")})
+	assert.True(t, errors.Is(err, ErrOTLPExportFailed))
+
+	// spans are kept while the collector is unreachable, and exported once it's back
+	assert.Nil(t, e.ExportSpans(context.Background(), []SpanData{newTestSpanData("e"), newTestSpanData("f")}))
+	assert.Nil(t, e.ExportSpans(context.Background(), []SpanData{newTestSpanData("g")}))
+
+	err = e.Flush(context.Background())
+	assert.True(t, errors.Is(err, ErrOTLPExportFailed))
+
+	mu.Lock()
+	status = http.StatusOK
+	requests = nil
+	mu.Unlock()
+
+	assert.Nil(t, e.Flush(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	names := []string{}
+
+	for _, req := range requests {
+		for _, span := range req.ResourceSpans[0].ScopeSpans[0].Spans {
+			names = append(names, span.Name)
+		}
+	}
+
+	assert.Equal(t, []string{"e", "f", "g"}, names)
+}
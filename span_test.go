@@ -0,0 +1,147 @@
+package webutils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qerrors "github.com/cyberhorsey/errors"
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func useTestSpanExporter(t *testing.T) *InMemorySpanExporter {
+	exporter := NewInMemorySpanExporter()
+	SetSpanExporter(exporter)
+
+	t.Cleanup(func() { SetSpanExporter(nil) })
+
+	return exporter
+}
+
+func Test_StartSpan(t *testing.T) {
+	exporter := useTestSpanExporter(t)
+
+	ctx := NewContext(context.Background(), "pid", "rid")
+
+	ctx, root := StartSpan(ctx, "root")
+	rootTC, _ := TraceContextFromContext(ctx)
+	assert.Equal(t, root, SpanFromContext(ctx))
+
+	childCtx, child := StartSpan(ctx, "child")
+	child.SetAttribute("db.table", "users")
+	child.AddEvent("cache miss", map[string]interface{}{"key": "u1"})
+	child.RecordError(qerrors.NotFound.New("user not found"))
+	child.End()
+	child.End()
+
+	// the child's trace context refers to the child, the parent's is unchanged
+	childID, _ := SpanIDFromContext(childCtx)
+	parentID, _ := SpanIDFromContext(ctx)
+	assert.NotEqual(t, childID, parentID)
+
+	root.End()
+
+	spans := exporter.Spans()
+	assert.Len(t, spans, 2)
+
+	c, r := spans[0], spans[1]
+	assert.Equal(t, "child", c.Name)
+	assert.Equal(t, SpanKindInternal, c.Kind)
+	assert.Equal(t, rootTC.TraceID, c.TraceID)
+	assert.Equal(t, r.SpanID, c.ParentSpanID)
+	assert.Equal(t, childID, c.SpanID)
+	assert.Equal(t, "pid", c.ProvenanceID)
+	assert.Equal(t, "rid", c.RequestID)
+	assert.Equal(t, "users", c.Attributes["db.table"])
+	assert.Len(t, c.Events, 2)
+	assert.Equal(t, "NotFound", c.ErrorType)
+	assert.Equal(t, "user not found", c.Error)
+	assert.True(t, c.Duration() >= 0)
+
+	assert.Equal(t, "", r.ParentSpanID)
+	assert.Equal(t, "", r.Error)
+
+	// nil spans are no-ops
+	var span *Span
+	span.SetAttribute("k", "v")
+	span.RecordError(ErrNoClaims)
+	span.End()
+	assert.Nil(t, SpanFromContext(context.Background()))
+}
+
+func Test_ProvenanceIDMiddleware_RootSpan(t *testing.T) {
+	exporter := useTestSpanExporter(t)
+
+	e := echo.New()
+	e.Use(ProvenanceIDMiddleware)
+	e.GET("/users/:id", func(c echo.Context) error {
+		_, span := StartSpan(c.Request().Context(), "load user")
+		defer span.End()
+
+		return LogAndRenderErrors(c, http.StatusForbidden, ErrTenantMismatch)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set(ProvenanceIDHeader, "pid")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	spans := exporter.Spans()
+	assert.Len(t, spans, 2)
+
+	child, root := spans[0], spans[1]
+	assert.Equal(t, "load user", child.Name)
+	assert.Equal(t, root.SpanID, child.ParentSpanID)
+
+	assert.Equal(t, "GET /users/:id", root.Name)
+	assert.Equal(t, SpanKindServer, root.Kind)
+	assert.Equal(t, "pid", root.ProvenanceID)
+	assert.Equal(t, rec.Header().Get(RequestIDHeader), root.RequestID)
+	assert.Equal(t, "/users/:id", root.Attributes["http.route"])
+	assert.Equal(t, http.StatusForbidden, root.Attributes["http.status_code"])
+	assert.Equal(t, "Forbidden", root.ErrorType)
+
+	// recorded once, by LogAndRenderErrors
+	assert.Len(t, root.Events, 1)
+	assert.Equal(t, "ERR_TENANT_MISMATCH", root.Events[0].Attributes["error.key"])
+}
+
+func Test_ProvenanceIDMiddleware_RootSpan_ReturnedError(t *testing.T) {
+	exporter := useTestSpanExporter(t)
+
+	e := echo.New()
+	e.Use(ProvenanceIDMiddleware)
+	e.GET("/teapot", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusTeapot)
+	})
+	e.GET("/boom", func(c echo.Context) error {
+		return qerrors.New("boom")
+	})
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{"/teapot", http.StatusTeapot},
+		{"/boom", http.StatusInternalServerError},
+		{"/missing", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			exporter.Reset()
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+
+			spans := exporter.Spans()
+			assert.Len(t, spans, 1)
+			assert.Equal(t, tt.wantStatus, spans[0].Attributes["http.status_code"])
+		})
+	}
+}
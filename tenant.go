@@ -0,0 +1,97 @@
+package webutils
+
+import (
+	"context"
+	"net/http"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+// HeaderTenantID is the default header naming the tenant a request is made for
+const HeaderTenantID = "X-Tenant-ID"
+
+// TenantFromContext returns the tenant of the authenticated claims in ctx
+func TenantFromContext(ctx context.Context) (string, bool) {
+	claims, err := GetJWTClaimsFromContext(ctx)
+	if err != nil || claims.TenantID == "" {
+		return "", false
+	}
+
+	return claims.TenantID, true
+}
+
+// TenantMiddlewareOpts contains the options for ConfigureTenantMiddleware
+type TenantMiddlewareOpts struct {
+	// Param is the name of the path parameter holding the tenant, ie: "tenantId" for
+	// "/tenants/:tenantId/users". Paths are not checked when empty. Requires echo's router, so
+	// adapted middleware with a Param reject every request; use PathTenant under net/http.
+	Param string
+	// PathTenant returns the tenant the request path names, or "" when it names none, ie: for
+	// net/http muxes without route params
+	PathTenant func(c echo.Context) string
+	// Header holding the tenant. Defaults to HeaderTenantID.
+	Header  string
+	Skipper func(c echo.Context) bool
+	// RequireTenant rejects requests whose claims carry no tenant, even when the request does not
+	// name one
+	RequireTenant bool
+}
+
+// tenantMiddleware isolates tenants from each other
+type tenantMiddleware struct {
+	Param         string
+	PathTenant    func(c echo.Context) string
+	Header        string
+	Skipper       func(c echo.Context) bool
+	RequireTenant bool
+}
+
+// ConfigureTenantMiddleware configures middleware rejecting requests whose path or header tenant
+// doesn't match the tenant of their claims. It must run after the authenticating middleware.
+func ConfigureTenantMiddleware(opts TenantMiddlewareOpts) echo.MiddlewareFunc {
+	mw := tenantMiddleware(opts)
+	if mw.Header == "" {
+		mw.Header = HeaderTenantID
+	}
+
+	if mw.Skipper == nil {
+		mw.Skipper = func(c echo.Context) bool { return false }
+	}
+
+	return mw.Handler
+}
+
+func (mw *tenantMiddleware) Handler(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if mw.Skipper(c) {
+			return next(c)
+		}
+
+		tenantID, ok := TenantFromContext(c.Request().Context())
+		if !ok && mw.RequireTenant {
+			return LogAndRenderErrors(c, http.StatusForbidden, ErrTenantRequired)
+		}
+
+		requested := []string{c.Request().Header.Get(mw.Header)}
+		if mw.PathTenant != nil {
+			requested = append(requested, mw.PathTenant(c))
+		}
+
+		if mw.Param != "" {
+			// adapted middleware run without route params, so c.Param would always pass
+			if _, adapted := echoContextFromRequest(c.Request()); adapted {
+				return LogAndRenderUnexpectedError(c, ErrTenantParamUnsupported)
+			}
+
+			requested = append(requested, c.Param(mw.Param))
+		}
+
+		for _, r := range requested {
+			if r != "" && r != tenantID {
+				return LogAndRenderErrors(c, http.StatusForbidden, ErrTenantMismatch)
+			}
+		}
+
+		return next(c)
+	}
+}
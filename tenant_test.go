@@ -0,0 +1,84 @@
+package webutils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TenantFromContext(t *testing.T) {
+	_, ok := TenantFromContext(context.Background())
+	assert.False(t, ok)
+
+	_, ok = TenantFromContext(newJWTContext(context.Background(), &Claims{}, "token"))
+	assert.False(t, ok)
+
+	tenantID, ok := TenantFromContext(newJWTContext(context.Background(), &Claims{TenantID: "42"}, "token"))
+	assert.True(t, ok)
+	assert.Equal(t, "42", tenantID)
+}
+
+func Test_TenantMiddleware(t *testing.T) {
+	jwtMiddleware, err := ConfigureJWTMiddleware(JWTMiddlewareOpts{PublicKey: testPublicKeyFunc, Optional: true})
+	assert.Nil(t, err)
+
+	newToken := func(tenantID string) string {
+		claims := newTestClaims(JWTAccess, time.Hour)
+		claims.TenantID = tenantID
+
+		return "Bearer " + newTestJWT(t, claims)
+	}
+
+	byPath := TenantMiddlewareOpts{Param: "tenantId"}
+
+	tests := []struct {
+		name          string
+		opts          TenantMiddlewareOpts
+		path          string
+		authorization string
+		header        string
+		wantStatus    int
+	}{
+		{"matching path", byPath, "/tenants/42/users", newToken("42"), "", http.StatusNoContent},
+		{"other tenant's path", byPath, "/tenants/7/users", newToken("42"), "", http.StatusForbidden},
+		{"matching header", TenantMiddlewareOpts{}, "/users", newToken("42"), "42", http.StatusNoContent},
+		{"other tenant's header", TenantMiddlewareOpts{}, "/users", newToken("42"), "7", http.StatusForbidden},
+		{"anonymous with tenant", byPath, "/tenants/42/users", "", "", http.StatusForbidden},
+		{"no tenant requested", TenantMiddlewareOpts{}, "/users", newToken(""), "", http.StatusNoContent},
+		{"tenant required", TenantMiddlewareOpts{RequireTenant: true}, "/users", newToken(""), "", http.StatusForbidden},
+		{"custom header", TenantMiddlewareOpts{Header: "X-Account-ID"}, "/users", newToken("42"), "7", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Use(jwtMiddleware, ConfigureTenantMiddleware(tt.opts))
+
+			handler := func(c echo.Context) error {
+				return c.NoContent(http.StatusNoContent)
+			}
+
+			e.GET("/users", handler)
+			e.GET("/tenants/:tenantId/users", handler)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set(echo.HeaderAuthorization, tt.authorization)
+			}
+
+			if tt.header != "" {
+				req.Header.Set(HeaderTenantID, tt.header)
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -93,6 +94,7 @@ func generateSignedJWT(accountID, userID uint, privateKey *rsa.PrivateKey) strin
 		webutils.Claims{
 			Username: "tester",
 			UserID:   userID,
+			TenantID: strconv.FormatUint(uint64(accountID), 10),
 			Type:     "access",
 			StandardClaims: jwt.StandardClaims{
 				Issuer:    "cyberhorsey",
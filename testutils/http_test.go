@@ -0,0 +1,23 @@
+package testutils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"testing"
+
+	"github.com/cyberhorsey/webutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewAuthenticatedRequest(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	r := NewAuthenticatedRequest(42, 7, key, http.MethodGet, "/users", nil)
+
+	claims, err := webutils.GetClaimsFromBearerJWT(r.Header.Get("Authorization"), &key.PublicKey)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(7), claims.UserID)
+	assert.Equal(t, "42", claims.TenantID)
+}
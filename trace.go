@@ -0,0 +1,166 @@
+package webutils
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+)
+
+// W3C Trace Context headers
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+const traceKey ctxKey = ctxKey(4202)
+
+const (
+	traceparentVersion = "00"
+	traceIDLength      = 16
+	spanIDLength       = 8
+	// traceparentLength is the length of a version 00 traceparent
+	traceparentLength = 2 + 1 + 2*traceIDLength + 1 + 2*spanIDLength + 1 + 2
+	// maxTracestateLength is the length beyond which tracestate may be discarded
+	maxTracestateLength = 512
+
+	// TraceFlagSampled indicates the caller may have recorded the trace
+	TraceFlagSampled byte = 0x01
+)
+
+// TraceContext is the W3C Trace Context of a request
+type TraceContext struct {
+	// TraceID identifies the whole trace, as 32 lowercase hex characters
+	TraceID string
+	// SpanID identifies the current operation, as 16 lowercase hex characters
+	SpanID string
+	// ParentSpanID is the caller's span id, empty for the root of a trace
+	ParentSpanID string
+	Flags        byte
+	// State is the vendor specific tracestate, propagated verbatim
+	State string
+}
+
+// Traceparent returns the traceparent header of calls made from the current span
+func (tc TraceContext) Traceparent() string {
+	return traceparentVersion + "-" + tc.TraceID + "-" + tc.SpanID + "-" + hex.EncodeToString([]byte{tc.Flags})
+}
+
+// Sampled indicates whether TraceFlagSampled is set
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&TraceFlagSampled != 0
+}
+
+// ParseTraceparent parses a traceparent header, returning a TraceContext whose ParentSpanID is the
+// header's parent id and whose SpanID is empty
+func ParseTraceparent(traceparent string) (TraceContext, error) {
+	traceparent = strings.TrimSpace(traceparent)
+
+	if len(traceparent) < traceparentLength {
+		return TraceContext{}, ErrInvalidTraceparent
+	}
+
+	version := traceparent[:2]
+
+	// version ff is forbidden, and version 00 has no further fields; later versions may add
+	// fields, which are ignored
+	switch {
+	case !isLowerHex(version) || version == "ff":
+		return TraceContext{}, ErrInvalidTraceparent
+	case version == traceparentVersion && len(traceparent) != traceparentLength:
+		return TraceContext{}, ErrInvalidTraceparent
+	case len(traceparent) > traceparentLength && traceparent[traceparentLength] != '-':
+		return TraceContext{}, ErrInvalidTraceparent
+	}
+
+	parts := strings.Split(traceparent[:traceparentLength], "-")
+	if len(parts) != 4 {
+		return TraceContext{}, ErrInvalidTraceparent
+	}
+
+	traceID, parentID, flags := parts[1], parts[2], parts[3]
+
+	if !isLowerHex(traceID) || !isLowerHex(parentID) || !isLowerHex(flags) ||
+		isZeroHex(traceID) || isZeroHex(parentID) {
+		return TraceContext{}, ErrInvalidTraceparent
+	}
+
+	flagBytes, _ := hex.DecodeString(flags)
+
+	return TraceContext{
+		TraceID:      traceID,
+		ParentSpanID: parentID,
+		Flags:        flagBytes[0],
+	}, nil
+}
+
+// newTraceContext continues the trace in the traceparent and tracestate headers with a new span,
+// or starts a new sampled trace when traceparent is missing or invalid
+func newTraceContext(traceparent, tracestate string) TraceContext {
+	tc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		tc = TraceContext{TraceID: newTraceID(), Flags: TraceFlagSampled}
+	} else if len(tracestate) <= maxTracestateLength {
+		tc.State = tracestate
+	}
+
+	tc.SpanID = newSpanID()
+
+	return tc
+}
+
+// WithTraceContext returns a copy of ctx carrying tc
+func WithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceKey, tc)
+}
+
+// TraceContextFromContext returns the trace context from context
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceKey).(TraceContext)
+	return tc, ok
+}
+
+// TraceIDFromContext returns the trace id from context
+func TraceIDFromContext(ctx context.Context) (string, bool) {
+	tc, ok := TraceContextFromContext(ctx)
+	return tc.TraceID, ok
+}
+
+// SpanIDFromContext returns the current span id from context
+func SpanIDFromContext(ctx context.Context) (string, bool) {
+	tc, ok := TraceContextFromContext(ctx)
+	return tc.SpanID, ok
+}
+
+// newTraceID generates a random trace id
+func newTraceID() string {
+	return newTraceHex(traceIDLength)
+}
+
+// newSpanID generates a random span id
+func newSpanID() string {
+	return newTraceHex(spanIDLength)
+}
+
+func newTraceHex(n int) string {
+	id, err := SecureRandomHex(n)
+	if err != nil || isZeroHex(id) {
+		// ids only have to be unique, so fall back to the correlation id generator
+		return newCorrelationID()[:2*n]
+	}
+
+	return id
+}
+
+func isLowerHex(s string) bool {
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isZeroHex(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
@@ -0,0 +1,138 @@
+package webutils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		want        TraceContext
+		wantErr     error
+	}{
+		{
+			"valid",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", ParentSpanID: "00f067aa0ba902b7", Flags: 1},
+			nil,
+		},
+		{
+			"not sampled",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", ParentSpanID: "00f067aa0ba902b7"},
+			nil,
+		},
+		{
+			"future version with extra fields",
+			"cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", ParentSpanID: "00f067aa0ba902b7", Flags: 1},
+			nil,
+		},
+		{"empty", "", TraceContext{}, ErrInvalidTraceparent},
+		{"version ff", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", TraceContext{}, ErrInvalidTraceparent},
+		{"version 00 extra fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x", TraceContext{},
+			ErrInvalidTraceparent},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", TraceContext{}, ErrInvalidTraceparent},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", TraceContext{},
+			ErrInvalidTraceparent},
+		{"zero parent id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", TraceContext{},
+			ErrInvalidTraceparent},
+		{"bad separator", "00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", TraceContext{},
+			ErrInvalidTraceparent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := ParseTraceparent(tt.traceparent)
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.want, tc)
+		})
+	}
+}
+
+func Test_TraceContext_Traceparent(t *testing.T) {
+	tc := TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Flags: 1}
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", tc.Traceparent())
+	assert.True(t, tc.Sampled())
+
+	parsed, err := ParseTraceparent(tc.Traceparent())
+	assert.Nil(t, err)
+	assert.Equal(t, tc.SpanID, parsed.ParentSpanID)
+}
+
+func Test_ProvenanceIDMiddleware_TraceContext(t *testing.T) {
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"
+
+	tests := []struct {
+		name             string
+		traceparent      string
+		provenanceID     string
+		wantTraceID      string
+		wantParent       string
+		wantProvenanceID string
+	}{
+		{"continued trace", traceparent, "", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7",
+			"4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"provenance header kept", traceparent, "pid", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", "pid"},
+		{"new trace", "", "", "", "", ""},
+		{"invalid traceparent", "00-garbage", "", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctx context.Context
+
+			h := ProvenanceIDMiddleware(func(c echo.Context) error {
+				ctx = c.Request().Context()
+				return c.NoContent(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(TraceparentHeader, tt.traceparent)
+			req.Header.Set(TracestateHeader, "vendor=abc")
+			req.Header.Set(ProvenanceIDHeader, tt.provenanceID)
+
+			assert.Nil(t, h(echo.New().NewContext(req, httptest.NewRecorder())))
+
+			tc, ok := TraceContextFromContext(ctx)
+			assert.True(t, ok)
+
+			traceID, _ := TraceIDFromContext(ctx)
+			spanID, _ := SpanIDFromContext(ctx)
+			pid, _ := ProvenanceIDFromContext(ctx)
+
+			assert.Len(t, traceID, 32)
+			assert.Len(t, spanID, 16)
+			assert.NotEqual(t, tt.wantParent, spanID)
+			assert.Equal(t, tt.wantParent, tc.ParentSpanID)
+
+			if tt.wantTraceID == "" {
+				// new traces are sampled and drop the orphaned tracestate
+				assert.True(t, tc.Sampled())
+				assert.Equal(t, "", tc.State)
+				assert.Equal(t, traceID, pid)
+
+				return
+			}
+
+			assert.Equal(t, tt.wantTraceID, traceID)
+			assert.Equal(t, tt.wantProvenanceID, pid)
+			assert.False(t, tc.Sampled())
+			assert.Equal(t, "vendor=abc", tc.State)
+		})
+	}
+}
+
+func Test_logFields_TraceContext(t *testing.T) {
+	ctx := WithTraceContext(context.Background(), TraceContext{TraceID: "trace", SpanID: "span"})
+	fields := logFields(ctx)
+	assert.Equal(t, "trace", fields["traceId"])
+	assert.Equal(t, "span", fields["spanId"])
+}
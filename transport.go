@@ -0,0 +1,163 @@
+package webutils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/cyberhorsey/errors"
+	echo "github.com/labstack/echo/v4"
+)
+
+// TransportDestination configures what is propagated to a destination of a PropagationTransport
+type TransportDestination struct {
+	// Host is the destination host, optionally with a port, ie: "users.internal:8080". A leading
+	// "*." matches any subdomain, ie: "*.internal".
+	Host string
+	// ForwardJWT forwards the JWT from the request context as a Bearer Authorization header
+	ForwardJWT bool
+	// TokenSource, when set, supplies the Bearer token for requests which have no JWT to forward,
+	// ie: those made by background workers
+	TokenSource TokenSource
+	// ForwardBaggage forwards the W3C baggage from the request context, within the transport's
+	// Baggage limits
+	ForwardBaggage bool
+}
+
+// PropagationTransportOpts contains the options for NewPropagationTransport
+type PropagationTransportOpts struct {
+	// Base performs the requests. Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+	// Destinations are the allowlisted hosts headers are propagated to. Requests to any other
+	// host are sent untouched.
+	Destinations []TransportDestination
+	// Baggage limits the baggage forwarded to destinations with ForwardBaggage
+	Baggage BaggageOpts
+}
+
+// propagationTransport is an http.RoundTripper propagating correlation and auth headers
+type propagationTransport struct {
+	base         http.RoundTripper
+	destinations []TransportDestination
+	baggage      *baggageLimits
+}
+
+// NewPropagationTransport creates an http.RoundTripper which propagates the provenance id, a
+// new per-hop request id, the W3C trace context and optionally the baggage and JWT from each
+// request's context, or a token from the destination's TokenSource, to allowlisted destinations.
+func NewPropagationTransport(opts PropagationTransportOpts) (http.RoundTripper, error) {
+	if len(opts.Destinations) == 0 {
+		return nil, ErrNoTransportDestinations
+	}
+
+	destinations := make([]TransportDestination, 0, len(opts.Destinations))
+
+	for _, d := range opts.Destinations {
+		if d.Host == "" || strings.Contains(d.Host, "/") {
+			return nil, ErrInvalidTransportHost
+		}
+
+		d.Host = strings.ToLower(d.Host)
+		destinations = append(destinations, d)
+	}
+
+	if opts.Base == nil {
+		opts.Base = http.DefaultTransport
+	}
+
+	return &propagationTransport{
+		base:         opts.Base,
+		destinations: destinations,
+		baggage:      newBaggageLimits(opts.Baggage),
+	}, nil
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *propagationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	dest, ok := t.destination(req.URL.Host)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the request they are given
+	req = req.Clone(req.Context())
+	ctx := req.Context()
+
+	if pid, ok := ProvenanceIDFromContext(ctx); ok && req.Header.Get(ProvenanceIDHeader) == "" {
+		req.Header.Set(ProvenanceIDHeader, pid)
+	}
+
+	if req.Header.Get(RequestIDHeader) == "" {
+		req.Header.Set(RequestIDHeader, newCorrelationID())
+	}
+
+	if tc, ok := TraceContextFromContext(ctx); ok && req.Header.Get(TraceparentHeader) == "" {
+		req.Header.Set(TraceparentHeader, tc.Traceparent())
+
+		if tc.State != "" {
+			req.Header.Set(TracestateHeader, tc.State)
+		}
+	}
+
+	if dest.ForwardBaggage && req.Header.Get(BaggageHeader) == "" {
+		if baggage := t.baggage.encode(BaggageFromContext(ctx)); baggage != "" {
+			req.Header.Set(BaggageHeader, baggage)
+		}
+	}
+
+	if dest.ForwardJWT && req.Header.Get(echo.HeaderAuthorization) == "" {
+		if jwt, err := GetJWTFromContext(ctx); err == nil {
+			req.Header.Set(echo.HeaderAuthorization, bearerPrefix+jwt)
+		}
+	}
+
+	if dest.TokenSource != nil && req.Header.Get(echo.HeaderAuthorization) == "" {
+		token, err := dest.TokenSource.Token(ctx)
+		if err != nil {
+			// RoundTrippers must close the request body, even on errors
+			if req.Body != nil {
+				req.Body.Close()
+			}
+
+			return nil, errors.Wrap(err, "dest.TokenSource.Token")
+		}
+
+		req.Header.Set(echo.HeaderAuthorization, bearerPrefix+token)
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// destination returns the allowlisted destination matching the URL host, if any
+func (t *propagationTransport) destination(urlHost string) (TransportDestination, bool) {
+	urlHost = strings.ToLower(urlHost)
+
+	hostname := urlHost
+	if h, _, err := net.SplitHostPort(urlHost); err == nil {
+		hostname = h
+	}
+
+	for _, d := range t.destinations {
+		// destinations with a port must match it exactly
+		candidate := hostname
+		if _, _, err := net.SplitHostPort(d.Host); err == nil {
+			candidate = urlHost
+		}
+
+		if matchHost(d.Host, candidate) {
+			return d, true
+		}
+	}
+
+	return TransportDestination{}, false
+}
+
+// matchHost matches host against pattern, where a leading "*." in pattern matches one or more
+// subdomains
+func matchHost(pattern, host string) bool {
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:]) && len(host) > len(pattern)-1
+	}
+
+	return pattern == host
+}
@@ -0,0 +1,147 @@
+package webutils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingTransport records the last request it was asked to send
+type recordingTransport struct {
+	req *http.Request
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.req = req
+	return httptest.NewRecorder().Result(), nil
+}
+
+func Test_NewPropagationTransport(t *testing.T) {
+	_, err := NewPropagationTransport(PropagationTransportOpts{})
+	assert.Equal(t, ErrNoTransportDestinations, err)
+
+	_, err = NewPropagationTransport(PropagationTransportOpts{
+		Destinations: []TransportDestination{{Host: ""}},
+	})
+	assert.Equal(t, ErrInvalidTransportHost, err)
+
+	_, err = NewPropagationTransport(PropagationTransportOpts{
+		Destinations: []TransportDestination{{Host: "users.internal/api"}},
+	})
+	assert.Equal(t, ErrInvalidTransportHost, err)
+}
+
+func Test_PropagationTransport(t *testing.T) {
+	base := &recordingTransport{}
+
+	rt, err := NewPropagationTransport(PropagationTransportOpts{
+		Base: base,
+		Destinations: []TransportDestination{
+			{Host: "users.internal", ForwardJWT: true},
+			{Host: "*.mesh.internal", ForwardJWT: true},
+			{Host: "metrics.internal:9090"},
+		},
+	})
+	assert.Nil(t, err)
+
+	ctx := NewContext(context.Background(), "pid", "rid")
+	ctx = newJWTContext(ctx, &Claims{}, "token")
+	ctx = WithTraceContext(ctx, TraceContext{
+		TraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:  "00f067aa0ba902b7",
+		Flags:   TraceFlagSampled,
+		State:   "vendor=abc",
+	})
+
+	tests := []struct {
+		name              string
+		url               string
+		header            http.Header
+		wantPropagated    bool
+		wantAuthorization string
+	}{
+		{"allowlisted host", "https://users.internal/users/1", nil, true, "Bearer token"},
+		{"allowlisted host with port", "https://Users.Internal:8443/users/1", nil, true, "Bearer token"},
+		{"wildcard subdomain", "http://orders.mesh.internal/orders", nil, true, "Bearer token"},
+		{"wildcard does not match apex", "http://mesh.internal/orders", nil, false, ""},
+		{"destination without jwt", "http://metrics.internal:9090/push", nil, true, ""},
+		{"destination port mismatch", "http://metrics.internal:8080/push", nil, false, ""},
+		{"third party", "https://api.example.com/charge", nil, false, ""},
+		{"suffix lookalike", "https://evilusers.internal/users/1", nil, false, ""},
+		{
+			"explicit authorization kept",
+			"https://users.internal/users/1",
+			http.Header{echo.HeaderAuthorization: []string{"Bearer other"}},
+			true,
+			"Bearer other",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			assert.Nil(t, err)
+
+			req := (&http.Request{Method: http.MethodGet, URL: u, Header: http.Header{}}).WithContext(ctx)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+
+			_, err = rt.RoundTrip(req)
+			assert.Nil(t, err)
+
+			sent := base.req
+			assert.Equal(t, tt.wantAuthorization, sent.Header.Get(echo.HeaderAuthorization))
+
+			if tt.wantPropagated {
+				assert.Equal(t, "pid", sent.Header.Get(ProvenanceIDHeader))
+				assert.NotEqual(t, "", sent.Header.Get(RequestIDHeader))
+				assert.NotEqual(t, "rid", sent.Header.Get(RequestIDHeader))
+				assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sent.Header.Get(TraceparentHeader))
+				assert.Equal(t, "vendor=abc", sent.Header.Get(TracestateHeader))
+				// the caller's request is left untouched
+				assert.Equal(t, "", req.Header.Get(ProvenanceIDHeader))
+			} else {
+				assert.Equal(t, "", sent.Header.Get(ProvenanceIDHeader))
+				assert.Equal(t, "", sent.Header.Get(RequestIDHeader))
+				assert.Equal(t, "", sent.Header.Get(TraceparentHeader))
+			}
+		})
+	}
+}
+
+func Test_PropagationTransport_PerHopRequestID(t *testing.T) {
+	var requestIDs []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestIDs = append(requestIDs, r.Header.Get(RequestIDHeader))
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	assert.Nil(t, err)
+
+	rt, err := NewPropagationTransport(PropagationTransportOpts{
+		Destinations: []TransportDestination{{Host: u.Host}},
+	})
+	assert.Nil(t, err)
+
+	client := &http.Client{Transport: rt}
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequestWithContext(NewContext(context.Background(), "pid", "rid"), http.MethodGet, srv.URL, nil)
+		assert.Nil(t, err)
+
+		res, err := client.Do(req)
+		assert.Nil(t, err)
+		res.Body.Close()
+	}
+
+	assert.Len(t, requestIDs, 2)
+	assert.NotEqual(t, requestIDs[0], requestIDs[1])
+}